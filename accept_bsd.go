@@ -0,0 +1,22 @@
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import "syscall"
+
+func accept(fd int) (int, syscall.Sockaddr, error) {
+	syscall.ForkLock.RLock()
+	ncfd, sa, err := syscall.Accept(fd)
+	if err == nil {
+		syscall.CloseOnExec(ncfd)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return -1, nil, err
+	}
+	if err := syscall.SetNonblock(ncfd, true); err != nil {
+		_ = syscall.Close(ncfd)
+		return -1, nil, err
+	}
+	return ncfd, sa, nil
+}
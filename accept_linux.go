@@ -0,0 +1,9 @@
+// +build linux
+
+package evnio
+
+import "syscall"
+
+func accept(fd int) (int, syscall.Sockaddr, error) {
+	return syscall.Accept4(fd, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+}
@@ -0,0 +1,169 @@
+package evnio
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrTooManyConnections      = errors.New("evnio: too many connections")
+	ErrTooManyConnectionsPerIP = errors.New("evnio: too many connections from address")
+	ErrConnectionRefused       = errors.New("evnio: connection refused by admission control")
+)
+
+// AcceptHandler may be implemented by a ConnectionHandler to decide whether an
+// accepted connection is served, it runs on the accepting loop before OnOpen. With
+// ProxyProtocol it runs on the connection's loop once the header is read and addr is
+// the client address the header carries.
+type AcceptHandler interface {
+	OnAccept(addr net.Addr) bool
+}
+
+// RejectHandler may be implemented by a ConnectionHandler to answer rejected connections,
+// the returned bytes are written once without blocking before the connection is closed.
+type RejectHandler interface {
+	OnReject(addr net.Addr, reason error) []byte
+}
+
+// admission enforces the connection limits of a server, it is shared by all accepting loops.
+type admission struct {
+	opts     *Options
+	mu       sync.Mutex
+	conns    int
+	perIP    map[string]int
+	rejected uint64
+}
+
+func newAdmission(opts *Options) *admission {
+	return &admission{
+		opts:  opts,
+		perIP: make(map[string]int),
+	}
+}
+
+// admit returns nil and counts the connection when addr may connect, release must
+// be called once the admitted connection is closed.
+func (a *admission) admit(addr net.Addr) error {
+	err := a.filter(addr)
+	if err == nil {
+		err = a.count(addr, true)
+	}
+	return a.counted(err)
+}
+
+// admitProxied refuses addr unless it is a trusted proxy and counts the connection against
+// MaxConnections while its PROXY header is read, readmit then applies the other rules to
+// the client address.
+func (a *admission) admitProxied(addr net.Addr) error {
+	if !proxyTrusted(a.opts.ProxyTrustedNetworks, addr) {
+		return a.counted(ErrConnectionRefused)
+	}
+	return a.counted(a.count(nil, true))
+}
+
+// readmit checks the client address of a connection counted by admitProxied, it is
+// counted under addr when admitted and still released with nil otherwise.
+func (a *admission) readmit(addr net.Addr) error {
+	err := a.filter(addr)
+	if err == nil {
+		err = a.count(addr, false)
+	}
+	return a.counted(err)
+}
+
+func (a *admission) counted(err error) error {
+	if err != nil {
+		atomic.AddUint64(&a.rejected, 1)
+	}
+	return err
+}
+
+// filter applies the network rules and OnAccept to addr.
+func (a *admission) filter(addr net.Addr) error {
+	ip := addrIP(addr)
+	if ip != nil {
+		if containsIP(a.opts.DenyNetworks, ip) {
+			return ErrConnectionRefused
+		}
+		if len(a.opts.AllowNetworks) > 0 && !containsIP(a.opts.AllowNetworks, ip) {
+			return ErrConnectionRefused
+		}
+	}
+	if h, ok := a.opts.Handler.(AcceptHandler); ok && !h.OnAccept(addr) {
+		return ErrConnectionRefused
+	}
+	return nil
+}
+
+// count takes a slot for addr, total is false when the connection already holds one
+// of MaxConnections. A nil addr is only counted against MaxConnections.
+func (a *admission) count(addr net.Addr, total bool) error {
+	if a.opts.MaxConnections <= 0 && a.opts.MaxConnectionsPerIP <= 0 {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if total && a.opts.MaxConnections > 0 && a.conns >= a.opts.MaxConnections {
+		return ErrTooManyConnections
+	}
+	if ip := addrIP(addr); a.opts.MaxConnectionsPerIP > 0 && ip != nil {
+		key := ip.String()
+		if a.perIP[key] >= a.opts.MaxConnectionsPerIP {
+			return ErrTooManyConnectionsPerIP
+		}
+		a.perIP[key]++
+	}
+	if total {
+		a.conns++
+	}
+	return nil
+}
+
+// release gives back the slot counted for addr, nil for a connection still reading its PROXY header.
+func (a *admission) release(addr net.Addr) {
+	if a.opts.MaxConnections <= 0 && a.opts.MaxConnectionsPerIP <= 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.conns--
+	if ip := addrIP(addr); a.opts.MaxConnectionsPerIP > 0 && ip != nil {
+		key := ip.String()
+		if a.perIP[key] <= 1 {
+			delete(a.perIP, key)
+		} else {
+			a.perIP[key]--
+		}
+	}
+}
+
+func (a *admission) Rejected() uint64 {
+	return atomic.LoadUint64(&a.rejected)
+}
+
+// addrIP returns the IP of addr, nil for unix sockets which the network and
+// per-IP rules then don't apply to.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
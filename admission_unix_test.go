@@ -0,0 +1,241 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type admissionHandler struct {
+	nopHandler
+	mu       sync.Mutex
+	accepted []string
+}
+
+func (h *admissionHandler) OnAccept(addr net.Addr) bool {
+	h.mu.Lock()
+	h.accepted = append(h.accepted, addr.String())
+	h.mu.Unlock()
+	return true
+}
+
+func (h *admissionHandler) OnReject(addr net.Addr, reason error) []byte {
+	return []byte(reason.Error())
+}
+
+func TestAdmissionBehindProxy(t *testing.T) {
+	_, deny, _ := net.ParseCIDR("10.9.0.0/16")
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	h := &admissionHandler{}
+	opts := NewOptions().
+		SetAddr("tcp://127.0.0.1:0").
+		SetNumLoops(2).
+		SetHandler(h).
+		SetProxyProtocol(ProxyProtocolRequired, []*net.IPNet{loopback}).
+		SetMaxConnectionsPerIP(1).
+		SetDenyNetworks([]*net.IPNet{deny})
+	srv := NewServer(opts)
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+	addr := listenAddr(t, srv).String()
+
+	// dial sends a v1 header for src in chunks, as a proxy writing it across reads would
+	dial := func(src string) net.Conn {
+		t.Helper()
+		nc, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := "PROXY TCP4 " + src + " 10.0.0.100 4000 80\r\n"
+		for _, chunk := range []string{header[:4], header[4:20], header[20:]} {
+			if _, err := nc.Write([]byte(chunk)); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return nc
+	}
+	rejected := func(nc net.Conn, reason error) {
+		t.Helper()
+		_ = nc.SetReadDeadline(time.Now().Add(time.Second))
+		b, err := ioutil.ReadAll(nc)
+		if err != nil || string(b) != reason.Error() {
+			t.Fatalf("read %q, %v, want %q", b, err, reason.Error())
+		}
+	}
+
+	first := dial("10.0.0.1")
+	waitFor(t, "first", func() bool { return srv.Count() == 1 })
+
+	// every peer is 127.0.0.1, limits and networks must use the proxied address
+	dup := dial("10.0.0.1")
+	defer dup.Close()
+	rejected(dup, ErrTooManyConnectionsPerIP)
+
+	second := dial("10.0.0.2")
+	defer second.Close()
+	waitFor(t, "second", func() bool { return srv.Count() == 2 })
+
+	denied := dial("10.9.0.1")
+	defer denied.Close()
+	rejected(denied, ErrConnectionRefused)
+
+	// the slot is released under the proxied address too
+	first.Close()
+	waitFor(t, "first closed", func() bool { return srv.Count() == 1 })
+	again := dial("10.0.0.1")
+	defer again.Close()
+	waitFor(t, "again", func() bool { return srv.Count() == 2 })
+
+	if n := srv.Stats().Rejected; n != 2 {
+		t.Fatalf("Rejected = %d, want 2", n)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	want := []string{"10.0.0.1:4000", "10.0.0.1:4000", "10.0.0.2:4000", "10.0.0.1:4000"}
+	if len(h.accepted) != len(want) {
+		t.Fatalf("OnAccept saw %v, want %v", h.accepted, want)
+	}
+	for i := range want {
+		if h.accepted[i] != want[i] {
+			t.Fatalf("OnAccept saw %v, want %v", h.accepted, want)
+		}
+	}
+}
+
+func TestProxyHeaderPending(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	opts := NewOptions().
+		SetAddr("tcp://127.0.0.1:0").
+		SetNumLoops(1).
+		SetHandler(&admissionHandler{}).
+		SetProxyProtocol(ProxyProtocolRequired, []*net.IPNet{loopback}).
+		SetProxyHeaderTimeout(100 * time.Millisecond).
+		SetMaxConnections(1)
+	srv := NewServer(opts)
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+	addr := listenAddr(t, srv).String()
+
+	silent, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	waitFor(t, "accept", func() bool { return srv.Stats().Accepted == 1 })
+
+	// a connection still waiting for its header holds a slot
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	_ = nc.SetReadDeadline(time.Now().Add(time.Second))
+	if b, err := ioutil.ReadAll(nc); err != nil || string(b) != ErrTooManyConnections.Error() {
+		t.Fatalf("read %q, %v, want %q", b, err, ErrTooManyConnections.Error())
+	}
+
+	// and is closed once the header timeout passes
+	_ = silent.SetReadDeadline(time.Now().Add(time.Second))
+	if b, err := ioutil.ReadAll(silent); err != nil || len(b) != 0 {
+		t.Fatalf("silent connection read %q, %v, want EOF", b, err)
+	}
+	nc, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	if _, err := nc.Write([]byte("PROXY TCP4 10.0.0.1 10.0.0.100 4000 80\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "proxied connection", func() bool { return srv.Count() == 1 })
+	// the header timeout is not an admission decision
+	if n := srv.Stats().Rejected; n != 1 {
+		t.Fatalf("Rejected = %d, want 1", n)
+	}
+}
+
+func TestUntrustedProxyRejected(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	opts := NewOptions().
+		SetAddr("tcp://127.0.0.1:0").
+		SetNumLoops(1).
+		SetHandler(&admissionHandler{}).
+		SetProxyProtocol(ProxyProtocolOptional, []*net.IPNet{trusted})
+	srv := NewServer(opts)
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+
+	nc, err := net.Dial("tcp", listenAddr(t, srv).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	_ = nc.SetReadDeadline(time.Now().Add(time.Second))
+	if b, err := ioutil.ReadAll(nc); err != nil || string(b) != ErrConnectionRefused.Error() {
+		t.Fatalf("read %q, %v, want %q", b, err, ErrConnectionRefused.Error())
+	}
+	if st := srv.Stats(); st.Rejected != 1 || srv.Count() != 0 {
+		t.Fatalf("Rejected = %d, Count() = %d, want 1, 0", st.Rejected, srv.Count())
+	}
+}
+
+func TestProxyHeaderKeepsProxyAddr(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	opts := NewOptions().
+		SetAddr("tcp://127.0.0.1:0").
+		SetNumLoops(1).
+		SetHandler(&admissionHandler{}).
+		SetProxyProtocol(ProxyProtocolRequired, []*net.IPNet{loopback}).
+		SetDenyNetworks([]*net.IPNet{loopback})
+	srv := NewServer(opts)
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+	addr := listenAddr(t, srv).String()
+
+	// UNIX and UDP headers don't replace the proxy's address, which DenyNetworks still sees
+	for _, header := range [][]byte{
+		proxyV2(0x21, 0x31, proxyV2Unix()),
+		proxyV2(0x21, 0x12, proxyV2TCP4()),
+	} {
+		nc, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer nc.Close()
+		if _, err := nc.Write(header); err != nil {
+			t.Fatal(err)
+		}
+		_ = nc.SetReadDeadline(time.Now().Add(time.Second))
+		if b, err := ioutil.ReadAll(nc); err != nil || string(b) != ErrConnectionRefused.Error() {
+			t.Fatalf("read %q, %v, want %q", b, err, ErrConnectionRefused.Error())
+		}
+	}
+	if n := srv.Count(); n != 0 {
+		t.Fatalf("Count() = %d, want 0", n)
+	}
+	if n := srv.Stats().Rejected; n != 2 {
+		t.Fatalf("Rejected = %d, want 2", n)
+	}
+}
+
+func TestAddrIP(t *testing.T) {
+	ip := net.ParseIP("10.0.0.1")
+	for _, addr := range []net.Addr{
+		&net.TCPAddr{IP: ip, Port: 1},
+		&net.UDPAddr{IP: ip, Port: 1},
+		&net.IPAddr{IP: ip},
+	} {
+		if got := addrIP(addr); !got.Equal(ip) {
+			t.Errorf("addrIP(%T) = %v, want %v", addr, got, ip)
+		}
+	}
+	if got := addrIP(&net.UnixAddr{Name: "/tmp/s", Net: "unix"}); got != nil {
+		t.Errorf("addrIP(unix) = %v, want nil", got)
+	}
+}
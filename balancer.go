@@ -0,0 +1,80 @@
+package evnio
+
+import (
+	"hash/fnv"
+	"net"
+	"sync/atomic"
+)
+
+// LoadBalancer picks the loop a new connection from addr is placed on.
+type LoadBalancer interface {
+	Select(loops []*EventLoop, addr net.Addr) *EventLoop
+}
+
+// LoadBalancerFunc adapts a function to a LoadBalancer.
+type LoadBalancerFunc func(loops []*EventLoop, addr net.Addr) *EventLoop
+
+func (f LoadBalancerFunc) Select(loops []*EventLoop, addr net.Addr) *EventLoop {
+	return f(loops, addr)
+}
+
+type roundRobinBalancer struct {
+	next uint64
+}
+
+func NewRoundRobinBalancer() LoadBalancer {
+	return &roundRobinBalancer{}
+}
+
+func (b *roundRobinBalancer) Select(loops []*EventLoop, addr net.Addr) *EventLoop {
+	i := atomic.AddUint64(&b.next, 1) - 1
+	return loops[i%uint64(len(loops))]
+}
+
+type leastConnectionsBalancer struct{}
+
+// NewLeastConnectionsBalancer places connections on the loop with the fewest live connections.
+func NewLeastConnectionsBalancer() LoadBalancer {
+	return &leastConnectionsBalancer{}
+}
+
+func (b *leastConnectionsBalancer) Select(loops []*EventLoop, addr net.Addr) *EventLoop {
+	loop := loops[0]
+	min := loop.NumConnections()
+	for _, l := range loops[1:] {
+		if n := l.NumConnections(); n < min {
+			loop, min = l, n
+		}
+	}
+	return loop
+}
+
+type sourceHashBalancer struct{}
+
+// NewSourceHashBalancer places every connection from one IP on the same loop.
+func NewSourceHashBalancer() LoadBalancer {
+	return &sourceHashBalancer{}
+}
+
+func (b *sourceHashBalancer) Select(loops []*EventLoop, addr net.Addr) *EventLoop {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(ip)
+	return loops[h.Sum32()%uint32(len(loops))]
+}
+
+func selectEventLoop(lb LoadBalancer, loops []*EventLoop, addr net.Addr) *EventLoop {
+	if loop := lb.Select(loops, addr); loop != nil {
+		return loop
+	}
+	return loops[0]
+}
@@ -0,0 +1,51 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestLeastConnectionsSpread(t *testing.T) {
+	const numLoops, numConns = 4, 40
+	srv := NewServer(NewOptions().
+		SetAddr("tcp://127.0.0.1:0").
+		SetNumLoops(numLoops).
+		SetLoadBalancer(NewLeastConnectionsBalancer()).
+		SetHandler(nopHandler{}))
+	loops := srv.EventLoops()
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+	addr := listenAddr(t, srv).String()
+
+	probe, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe.Close()
+	waitFor(t, "probe close", func() bool { return srv.Count() == 0 })
+
+	// connect the burst before any of it is accepted
+	conns := make([]net.Conn, 0, numConns)
+	defer func() {
+		for _, nc := range conns {
+			nc.Close()
+		}
+	}()
+	for i := 0; i < numConns; i++ {
+		nc, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, nc)
+	}
+	waitFor(t, "connections", func() bool { return srv.Count() == numConns })
+
+	for i, loop := range loops {
+		if n := loop.NumConnections(); n != numConns/numLoops {
+			t.Errorf("loop %d has %d connections, want %d", i, n, numConns/numLoops)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package evnio
+
+import (
+	"bytes"
+	"errors"
+	"net"
+)
+
+var errCodecOverrun = errors.New("evnio: codec consumed more than its input")
+
+// Codec frames a connection's stream like Protocol but can report a malformed stream.
+//
+// Decode returns the first frame of data and how many bytes of data it took, a zero
+// consumed with a nil error means more data is needed. data is a read-only view of the
+// connection's read buffer and the frame may point into it, it is only valid until
+// OnMessage returns. Encode may return data itself or a new slice, not a part of data.
+// An error from Decode or Encode is given to the handler's OnError and closes the
+// connection.
+type Codec interface {
+	Decode(c Connection, data []byte) (frame []byte, consumed int, err error)
+	Encode(c Connection, data []byte) ([]byte, error)
+}
+
+// BuffersCodec may be implemented by a Codec to encode the buffers given to SendBuffers
+// without joining them.
+type BuffersCodec interface {
+	EncodeBuffers(c Connection, buffers net.Buffers) (net.Buffers, error)
+}
+
+// ErrorHandler may be implemented by a ConnectionHandler to be told why a Codec failed,
+// the connection stops reading and is closed once the data sent from OnError is written.
+type ErrorHandler interface {
+	OnError(c Connection, err error)
+}
+
+// protocolCodec adapts a Protocol to Codec.
+type protocolCodec struct {
+	p Protocol
+}
+
+// NewProtocolCodec returns a Codec using p, it never fails and an empty frame from
+// UnPacket means more data is needed.
+func NewProtocolCodec(p Protocol) Codec {
+	return &protocolCodec{p: p}
+}
+
+func (pc *protocolCodec) Decode(c Connection, data []byte) ([]byte, int, error) {
+	buffer := bytes.NewBuffer(data)
+	frame := pc.p.UnPacket(c, buffer)
+	if len(frame) == 0 {
+		return nil, 0, nil
+	}
+	return frame, len(data) - buffer.Len(), nil
+}
+
+func (pc *protocolCodec) Encode(c Connection, data []byte) ([]byte, error) {
+	return pc.p.Packet(c, data), nil
+}
+
+func (pc *protocolCodec) EncodeBuffers(c Connection, buffers net.Buffers) (net.Buffers, error) {
+	return packetBuffers(pc.p, c, buffers), nil
+}
+
+func newCodec(opts *Options) Codec {
+	if opts.Codec != nil {
+		return opts.Codec
+	}
+	if codec, ok := opts.Protocol.(Codec); ok {
+		return codec
+	}
+	if opts.Protocol != nil {
+		return NewProtocolCodec(opts.Protocol)
+	}
+	return NewProtocolCodec(&defaultProtocol{})
+}
+
+// nextFrame takes the next frame out of buffer, ok is false when more data is needed.
+func nextFrame(codec Codec, c Connection, buffer *bytes.Buffer) (frame []byte, ok bool, err error) {
+	// a Protocol keeps working on the buffer itself
+	if pc, isProtocol := codec.(*protocolCodec); isProtocol {
+		frame = pc.p.UnPacket(c, buffer)
+		return frame, len(frame) > 0, nil
+	}
+	data := buffer.Bytes()
+	frame, n, err := codec.Decode(c, data)
+	if err != nil {
+		return nil, false, err
+	}
+	if n < 0 || n > len(data) {
+		return nil, false, errCodecOverrun
+	}
+	if n == 0 {
+		return nil, false, nil
+	}
+	buffer.Next(n)
+	return frame, true, nil
+}
+
+func encodeBuffers(codec Codec, c Connection, buffers net.Buffers) (net.Buffers, error) {
+	if bc, ok := codec.(BuffersCodec); ok {
+		return bc.EncodeBuffers(c, buffers)
+	}
+	data, err := codec.Encode(c, bytes.Join(buffers, nil))
+	if err != nil {
+		return nil, err
+	}
+	return net.Buffers{data}, nil
+}
@@ -0,0 +1,49 @@
+// Package codec provides frame codecs usable as an evnio.Codec or evnio.Protocol.
+package codec
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/dreamans/evnio"
+)
+
+var (
+	ErrFrameTooLarge      = errors.New("codec: frame too large")
+	ErrInvalidLength      = errors.New("codec: invalid frame length")
+	ErrInvalidFieldLength = errors.New("codec: length field must be 1, 2, 4 or 8 bytes")
+	ErrInvalidDelimiter   = errors.New("codec: empty delimiter")
+)
+
+const maxInt = uint64(^uint(0) >> 1)
+
+// unPacket implements evnio.Protocol's UnPacket on top of a Codec so the codecs can be
+// used as a Protocol. A Protocol can't report errors, so a malformed stream closes the
+// connection, and empty frames are skipped since an empty result means more data is needed.
+func unPacket(codec evnio.Codec, c evnio.Connection, buffer *bytes.Buffer) []byte {
+	for {
+		frame, n, err := codec.Decode(c, buffer.Bytes())
+		if err != nil {
+			_ = c.Close()
+			return nil
+		}
+		if n == 0 {
+			return nil
+		}
+		buffer.Next(n)
+		if len(frame) > 0 {
+			return frame
+		}
+	}
+}
+
+// packet implements evnio.Protocol's Packet on top of a Codec, data that can't be encoded
+// closes the connection.
+func packet(codec evnio.Codec, c evnio.Connection, data []byte) []byte {
+	packed, err := codec.Encode(c, data)
+	if err != nil {
+		_ = c.Close()
+		return nil
+	}
+	return packed
+}
@@ -0,0 +1,151 @@
+package codec
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/dreamans/evnio"
+)
+
+// DelimiterCodec frames messages ending with Delimiter.
+type DelimiterCodec struct {
+	Delimiter []byte
+	// MaxFrameLength bounds frames without their delimiter, zero is unlimited.
+	// The connection is closed once a frame can't end within it.
+	MaxFrameLength int
+	// KeepDelimiter leaves the delimiter at the end of decoded frames.
+	KeepDelimiter bool
+}
+
+func (dc *DelimiterCodec) Decode(c evnio.Connection, data []byte) ([]byte, int, error) {
+	if len(dc.Delimiter) == 0 {
+		return nil, 0, ErrInvalidDelimiter
+	}
+	i := bytes.Index(data, dc.Delimiter)
+	if i == -1 {
+		// the delimiter may have started in the last bytes
+		if dc.MaxFrameLength > 0 && len(data)-len(dc.Delimiter)+1 > dc.MaxFrameLength {
+			return nil, 0, ErrFrameTooLarge
+		}
+		return nil, 0, nil
+	}
+	if dc.MaxFrameLength > 0 && i > dc.MaxFrameLength {
+		return nil, 0, ErrFrameTooLarge
+	}
+	n := i + len(dc.Delimiter)
+	if dc.KeepDelimiter {
+		return data[:n], n, nil
+	}
+	return data[:i], n, nil
+}
+
+func (dc *DelimiterCodec) Encode(c evnio.Connection, data []byte) ([]byte, error) {
+	if len(dc.Delimiter) == 0 {
+		return nil, ErrInvalidDelimiter
+	}
+	if dc.MaxFrameLength > 0 && len(data) > dc.MaxFrameLength {
+		return nil, ErrFrameTooLarge
+	}
+	b := make([]byte, 0, len(data)+len(dc.Delimiter))
+	return append(append(b, data...), dc.Delimiter...), nil
+}
+
+func (dc *DelimiterCodec) EncodeBuffers(c evnio.Connection, buffers net.Buffers) (net.Buffers, error) {
+	if len(dc.Delimiter) == 0 {
+		return nil, ErrInvalidDelimiter
+	}
+	if err := checkLength(buffers, dc.MaxFrameLength); err != nil {
+		return nil, err
+	}
+	return append(buffers[:len(buffers):len(buffers)], dc.Delimiter), nil
+}
+
+// UnPacket implements evnio.Protocol.
+func (dc *DelimiterCodec) UnPacket(c evnio.Connection, buffer *bytes.Buffer) []byte {
+	return unPacket(dc, c, buffer)
+}
+
+// Packet implements evnio.Protocol.
+func (dc *DelimiterCodec) Packet(c evnio.Connection, data []byte) []byte {
+	return packet(dc, c, data)
+}
+
+// LineCodec frames text lines ending with "\n" or "\r\n".
+type LineCodec struct {
+	// MaxFrameLength bounds lines without their terminator, zero is unlimited.
+	// The connection is closed once a line can't end within it.
+	MaxFrameLength int
+	// KeepDelimiter leaves the line terminator at the end of decoded lines.
+	KeepDelimiter bool
+	// CRLF makes Encode end lines with "\r\n" instead of "\n".
+	CRLF bool
+}
+
+func (lc *LineCodec) Decode(c evnio.Connection, data []byte) ([]byte, int, error) {
+	i := bytes.IndexByte(data, '\n')
+	if i == -1 {
+		// a "\r" may be waiting for its "\n"
+		if lc.MaxFrameLength > 0 && len(data) > lc.MaxFrameLength+1 {
+			return nil, 0, ErrFrameTooLarge
+		}
+		return nil, 0, nil
+	}
+	end := i
+	if end > 0 && data[end-1] == '\r' {
+		end--
+	}
+	if lc.MaxFrameLength > 0 && end > lc.MaxFrameLength {
+		return nil, 0, ErrFrameTooLarge
+	}
+	if lc.KeepDelimiter {
+		return data[:i+1], i + 1, nil
+	}
+	return data[:end], i + 1, nil
+}
+
+func (lc *LineCodec) Encode(c evnio.Connection, data []byte) ([]byte, error) {
+	if lc.MaxFrameLength > 0 && len(data) > lc.MaxFrameLength {
+		return nil, ErrFrameTooLarge
+	}
+	eol := lc.eol()
+	b := make([]byte, 0, len(data)+len(eol))
+	return append(append(b, data...), eol...), nil
+}
+
+func (lc *LineCodec) EncodeBuffers(c evnio.Connection, buffers net.Buffers) (net.Buffers, error) {
+	if err := checkLength(buffers, lc.MaxFrameLength); err != nil {
+		return nil, err
+	}
+	return append(buffers[:len(buffers):len(buffers)], lc.eol()), nil
+}
+
+func (lc *LineCodec) eol() []byte {
+	if lc.CRLF {
+		return []byte("\r\n")
+	}
+	return []byte("\n")
+}
+
+// UnPacket implements evnio.Protocol.
+func (lc *LineCodec) UnPacket(c evnio.Connection, buffer *bytes.Buffer) []byte {
+	return unPacket(lc, c, buffer)
+}
+
+// Packet implements evnio.Protocol.
+func (lc *LineCodec) Packet(c evnio.Connection, data []byte) []byte {
+	return packet(lc, c, data)
+}
+
+func checkLength(buffers net.Buffers, max int) error {
+	if max <= 0 {
+		return nil
+	}
+	n := 0
+	for _, b := range buffers {
+		n += len(b)
+	}
+	if n > max {
+		return ErrFrameTooLarge
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package codec
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/dreamans/evnio"
+)
+
+// decodeStream feeds stream to codec one byte at a time, as split reads would.
+func decodeStream(t *testing.T, codec evnio.Codec, stream []byte) []string {
+	t.Helper()
+	var buf []byte
+	var frames []string
+	for _, b := range stream {
+		buf = append(buf, b)
+		for {
+			frame, n, err := codec.Decode(nil, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n == 0 {
+				break
+			}
+			frames = append(frames, string(frame))
+			buf = buf[n:]
+		}
+	}
+	if len(buf) != 0 {
+		t.Fatalf("undecoded %q", buf)
+	}
+	return frames
+}
+
+func TestDelimiterDecode(t *testing.T) {
+	crlf := []byte("\r\n")
+	tests := []struct {
+		name  string
+		codec DelimiterCodec
+		in    string
+		frame string
+		n     int
+		err   error
+	}{
+		{name: "frame", codec: DelimiterCodec{Delimiter: crlf}, in: "hi\r\nrest", frame: "hi", n: 4},
+		{name: "keep delimiter", codec: DelimiterCodec{Delimiter: crlf, KeepDelimiter: true}, in: "hi\r\n", frame: "hi\r\n", n: 4},
+		{name: "empty frame", codec: DelimiterCodec{Delimiter: crlf}, in: "\r\n", n: 2},
+		{name: "no delimiter yet", codec: DelimiterCodec{Delimiter: crlf}, in: "hi\r"},
+		{name: "empty delimiter", codec: DelimiterCodec{}, in: "hi", err: ErrInvalidDelimiter},
+		{name: "at max", codec: DelimiterCodec{Delimiter: crlf, MaxFrameLength: 2}, in: "hi\r\n", frame: "hi", n: 4},
+		{name: "over max", codec: DelimiterCodec{Delimiter: crlf, MaxFrameLength: 2}, in: "hey\r\n", err: ErrFrameTooLarge},
+		{name: "at max, delimiter pending", codec: DelimiterCodec{Delimiter: crlf, MaxFrameLength: 2}, in: "hi\r"},
+		{name: "over max without delimiter", codec: DelimiterCodec{Delimiter: crlf, MaxFrameLength: 2}, in: "hey"},
+		{name: "over max, partial delimiter", codec: DelimiterCodec{Delimiter: crlf, MaxFrameLength: 2}, in: "hey\r", err: ErrFrameTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, n, err := tt.codec.Decode(nil, []byte(tt.in))
+			if err != tt.err || n != tt.n || string(frame) != tt.frame {
+				t.Fatalf("Decode = %q, %d, %v, want %q, %d, %v", frame, n, err, tt.frame, tt.n, tt.err)
+			}
+		})
+	}
+}
+
+func TestDelimiterSplitReads(t *testing.T) {
+	dc := &DelimiterCodec{Delimiter: []byte("||"), MaxFrameLength: 5}
+	frames := decodeStream(t, dc, []byte("hello||wo|ld||||"))
+	want := []string{"hello", "wo|ld", ""}
+	if len(frames) != len(want) {
+		t.Fatalf("frames = %q, want %q", frames, want)
+	}
+	for i := range want {
+		if frames[i] != want[i] {
+			t.Fatalf("frames = %q, want %q", frames, want)
+		}
+	}
+}
+
+func TestDelimiterEncode(t *testing.T) {
+	dc := &DelimiterCodec{Delimiter: []byte("\x00"), MaxFrameLength: 3}
+	if out, err := dc.Encode(nil, []byte("abc")); err != nil || string(out) != "abc\x00" {
+		t.Fatalf("Encode = %q, %v", out, err)
+	}
+	if _, err := dc.Encode(nil, []byte("abcd")); err != ErrFrameTooLarge {
+		t.Fatalf("Encode over max = %v, want ErrFrameTooLarge", err)
+	}
+	if _, err := dc.EncodeBuffers(nil, net.Buffers{[]byte("ab"), []byte("cd")}); err != ErrFrameTooLarge {
+		t.Fatalf("EncodeBuffers over max = %v, want ErrFrameTooLarge", err)
+	}
+	in := net.Buffers{[]byte("a"), []byte("bc")}
+	out, err := dc.EncodeBuffers(nil, in)
+	if err != nil || len(out) != 3 || !bytes.Equal(out[2], []byte("\x00")) {
+		t.Fatalf("EncodeBuffers = %q, %v", out, err)
+	}
+	if len(in) != 2 {
+		t.Fatal("EncodeBuffers modified its input")
+	}
+	if _, err := (&DelimiterCodec{}).Encode(nil, []byte("a")); err != ErrInvalidDelimiter {
+		t.Fatalf("Encode without delimiter = %v, want ErrInvalidDelimiter", err)
+	}
+}
+
+func TestLineDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec LineCodec
+		in    string
+		frame string
+		n     int
+		err   error
+	}{
+		{name: "lf", in: "hi\nrest", frame: "hi", n: 3},
+		{name: "crlf", in: "hi\r\nrest", frame: "hi", n: 4},
+		{name: "keep lf", codec: LineCodec{KeepDelimiter: true}, in: "hi\n", frame: "hi\n", n: 3},
+		{name: "keep crlf", codec: LineCodec{KeepDelimiter: true}, in: "hi\r\n", frame: "hi\r\n", n: 4},
+		{name: "lone cr", in: "a\rb\n", frame: "a\rb", n: 4},
+		{name: "empty line", in: "\r\n", n: 2},
+		{name: "no terminator yet", in: "hi\r"},
+		{name: "at max lf", codec: LineCodec{MaxFrameLength: 2}, in: "hi\n", frame: "hi", n: 3},
+		{name: "at max crlf", codec: LineCodec{MaxFrameLength: 2}, in: "hi\r\n", frame: "hi", n: 4},
+		{name: "over max", codec: LineCodec{MaxFrameLength: 2}, in: "hey\n", err: ErrFrameTooLarge},
+		{name: "at max, cr pending", codec: LineCodec{MaxFrameLength: 2}, in: "hi\r"},
+		{name: "over max without terminator", codec: LineCodec{MaxFrameLength: 2}, in: "heyo", err: ErrFrameTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, n, err := tt.codec.Decode(nil, []byte(tt.in))
+			if err != tt.err || n != tt.n || string(frame) != tt.frame {
+				t.Fatalf("Decode = %q, %d, %v, want %q, %d, %v", frame, n, err, tt.frame, tt.n, tt.err)
+			}
+		})
+	}
+}
+
+func TestLineSplitReads(t *testing.T) {
+	frames := decodeStream(t, &LineCodec{MaxFrameLength: 5}, []byte("hello\r\nworld\n\r\n"))
+	if len(frames) != 3 || frames[0] != "hello" || frames[1] != "world" || frames[2] != "" {
+		t.Fatalf("frames = %q", frames)
+	}
+}
+
+func TestLineEncode(t *testing.T) {
+	if out, err := (&LineCodec{}).Encode(nil, []byte("hi")); err != nil || string(out) != "hi\n" {
+		t.Fatalf("Encode = %q, %v", out, err)
+	}
+	if out, err := (&LineCodec{CRLF: true}).Encode(nil, []byte("hi")); err != nil || string(out) != "hi\r\n" {
+		t.Fatalf("Encode CRLF = %q, %v", out, err)
+	}
+	if _, err := (&LineCodec{MaxFrameLength: 1}).Encode(nil, []byte("hi")); err != ErrFrameTooLarge {
+		t.Fatalf("Encode over max = %v, want ErrFrameTooLarge", err)
+	}
+	out, err := (&LineCodec{CRLF: true}).EncodeBuffers(nil, net.Buffers{[]byte("h"), []byte("i")})
+	if err != nil || len(out) != 3 || string(out[2]) != "\r\n" {
+		t.Fatalf("EncodeBuffers = %q, %v", out, err)
+	}
+}
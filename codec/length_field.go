@@ -0,0 +1,145 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+
+	"github.com/dreamans/evnio"
+)
+
+// LengthFieldCodec frames messages behind a fixed size length field, the zero value
+// uses a 4 byte big endian field counting the bytes that follow it.
+type LengthFieldCodec struct {
+	// FieldLength is the size of the length field: 1, 2, 4 or 8 bytes, 4 when zero.
+	FieldLength int
+	// ByteOrder of the length field, binary.BigEndian when nil.
+	ByteOrder binary.ByteOrder
+	// Adjustment is added to the length field to get the payload length,
+	// use -FieldLength when the field counts itself.
+	Adjustment int
+	// Strip removes the length field from decoded frames.
+	Strip bool
+	// MaxFrameLength bounds the payload length, zero is unlimited.
+	MaxFrameLength int
+}
+
+func (lf *LengthFieldCodec) fieldLength() (int, error) {
+	switch lf.FieldLength {
+	case 0:
+		return 4, nil
+	case 1, 2, 4, 8:
+		return lf.FieldLength, nil
+	}
+	return 0, ErrInvalidFieldLength
+}
+
+func (lf *LengthFieldCodec) byteOrder() binary.ByteOrder {
+	if lf.ByteOrder == nil {
+		return binary.BigEndian
+	}
+	return lf.ByteOrder
+}
+
+func (lf *LengthFieldCodec) Decode(c evnio.Connection, data []byte) ([]byte, int, error) {
+	size, err := lf.fieldLength()
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < size {
+		return nil, 0, nil
+	}
+
+	var field uint64
+	switch order := lf.byteOrder(); size {
+	case 1:
+		field = uint64(data[0])
+	case 2:
+		field = uint64(order.Uint16(data))
+	case 4:
+		field = uint64(order.Uint32(data))
+	case 8:
+		field = order.Uint64(data)
+	}
+	if field > math.MaxInt32 {
+		return nil, 0, ErrFrameTooLarge
+	}
+	length := int64(field) + int64(lf.Adjustment)
+	if length < 0 {
+		return nil, 0, ErrInvalidLength
+	}
+	if (lf.MaxFrameLength > 0 && length > int64(lf.MaxFrameLength)) || length > math.MaxInt32-int64(size) {
+		return nil, 0, ErrFrameTooLarge
+	}
+
+	total := size + int(length)
+	if len(data) < total {
+		return nil, 0, nil
+	}
+	if lf.Strip {
+		return data[size:total], total, nil
+	}
+	return data[:total], total, nil
+}
+
+func (lf *LengthFieldCodec) Encode(c evnio.Connection, data []byte) ([]byte, error) {
+	header, err := lf.header(len(data), len(data))
+	if err != nil {
+		return nil, err
+	}
+	return append(header, data...), nil
+}
+
+func (lf *LengthFieldCodec) EncodeBuffers(c evnio.Connection, buffers net.Buffers) (net.Buffers, error) {
+	n := 0
+	for _, b := range buffers {
+		n += len(b)
+	}
+	header, err := lf.header(n, 0)
+	if err != nil {
+		return nil, err
+	}
+	return append(net.Buffers{header}, buffers...), nil
+}
+
+// header returns the length field for a payload of n bytes, with room for room more bytes.
+func (lf *LengthFieldCodec) header(n, room int) ([]byte, error) {
+	size, err := lf.fieldLength()
+	if err != nil {
+		return nil, err
+	}
+	if lf.MaxFrameLength > 0 && n > lf.MaxFrameLength {
+		return nil, ErrFrameTooLarge
+	}
+	field := int64(n) - int64(lf.Adjustment)
+	if field < 0 {
+		return nil, ErrInvalidLength
+	}
+	if size < 8 && uint64(field) >= 1<<(8*uint(size)) {
+		return nil, ErrFrameTooLarge
+	}
+
+	header := make([]byte, size, size+room)
+	switch order := lf.byteOrder(); size {
+	case 1:
+		header[0] = byte(field)
+	case 2:
+		order.PutUint16(header, uint16(field))
+	case 4:
+		order.PutUint32(header, uint32(field))
+	case 8:
+		order.PutUint64(header, uint64(field))
+	}
+	return header, nil
+}
+
+// UnPacket implements evnio.Protocol.
+func (lf *LengthFieldCodec) UnPacket(c evnio.Connection, buffer *bytes.Buffer) []byte {
+	return unPacket(lf, c, buffer)
+}
+
+// Packet implements evnio.Protocol.
+func (lf *LengthFieldCodec) Packet(c evnio.Connection, data []byte) []byte {
+	return packet(lf, c, data)
+}
@@ -0,0 +1,141 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestLengthFieldDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec LengthFieldCodec
+		in    []byte
+		frame []byte
+		n     int
+		err   error
+	}{
+		{name: "default", in: []byte{0, 0, 0, 2, 'h', 'i', 'x'}, frame: []byte{0, 0, 0, 2, 'h', 'i'}, n: 6},
+		{name: "1 byte", codec: LengthFieldCodec{FieldLength: 1}, in: []byte{2, 'h', 'i'}, frame: []byte{2, 'h', 'i'}, n: 3},
+		{name: "2 bytes", codec: LengthFieldCodec{FieldLength: 2}, in: []byte{0, 2, 'h', 'i'}, frame: []byte{0, 2, 'h', 'i'}, n: 4},
+		{name: "8 bytes", codec: LengthFieldCodec{FieldLength: 8}, in: []byte{0, 0, 0, 0, 0, 0, 0, 2, 'h', 'i'}, frame: []byte{0, 0, 0, 0, 0, 0, 0, 2, 'h', 'i'}, n: 10},
+		{name: "little endian", codec: LengthFieldCodec{FieldLength: 2, ByteOrder: binary.LittleEndian}, in: []byte{2, 0, 'h', 'i'}, frame: []byte{2, 0, 'h', 'i'}, n: 4},
+		{name: "invalid width", codec: LengthFieldCodec{FieldLength: 3}, in: []byte{0, 0, 2, 'h', 'i'}, err: ErrInvalidFieldLength},
+		{name: "strip", codec: LengthFieldCodec{Strip: true}, in: []byte{0, 0, 0, 2, 'h', 'i'}, frame: []byte("hi"), n: 6},
+		{name: "empty", codec: LengthFieldCodec{Strip: true}, in: []byte{0, 0, 0, 0}, frame: []byte{}, n: 4},
+		{name: "field counts itself", codec: LengthFieldCodec{FieldLength: 2, Adjustment: -2, Strip: true}, in: []byte{0, 4, 'h', 'i'}, frame: []byte("hi"), n: 4},
+		{name: "positive adjustment", codec: LengthFieldCodec{FieldLength: 1, Adjustment: 2, Strip: true}, in: []byte{1, 'h', 'i', '!'}, frame: []byte("hi!"), n: 4},
+		{name: "negative length", codec: LengthFieldCodec{FieldLength: 2, Adjustment: -2}, in: []byte{0, 1, 'h'}, err: ErrInvalidLength},
+		{name: "partial field", in: []byte{0, 0, 0}},
+		{name: "partial payload", in: []byte{0, 0, 0, 5, 'h', 'i'}},
+		{name: "over max", codec: LengthFieldCodec{MaxFrameLength: 4}, in: []byte{0, 0, 0, 5}, err: ErrFrameTooLarge},
+		{name: "at max", codec: LengthFieldCodec{MaxFrameLength: 2, Strip: true}, in: []byte{0, 0, 0, 2, 'h', 'i'}, frame: []byte("hi"), n: 6},
+		{name: "over int32", codec: LengthFieldCodec{FieldLength: 8}, in: []byte{0, 0, 0, 1, 0, 0, 0, 0}, err: ErrFrameTooLarge},
+		{name: "int32 with adjustment", codec: LengthFieldCodec{Adjustment: 1}, in: []byte{0x7f, 0xff, 0xff, 0xff}, err: ErrFrameTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, n, err := tt.codec.Decode(nil, tt.in)
+			if err != tt.err || n != tt.n || !bytes.Equal(frame, tt.frame) {
+				t.Fatalf("Decode = %q, %d, %v, want %q, %d, %v", frame, n, err, tt.frame, tt.n, tt.err)
+			}
+		})
+	}
+}
+
+func TestLengthFieldDecodeOverMaxDoesNotAllocate(t *testing.T) {
+	lf := &LengthFieldCodec{MaxFrameLength: 1 << 10}
+	header := []byte{0x7f, 0xff, 0xff, 0xff}
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, _, err := lf.Decode(nil, header); err != ErrFrameTooLarge {
+			t.Fatalf("err = %v, want ErrFrameTooLarge", err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("Decode allocated %v times for an oversized frame", allocs)
+	}
+}
+
+func TestLengthFieldEncode(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec LengthFieldCodec
+		in    []byte
+		out   []byte
+		err   error
+	}{
+		{name: "default", in: []byte("hi"), out: []byte{0, 0, 0, 2, 'h', 'i'}},
+		{name: "1 byte", codec: LengthFieldCodec{FieldLength: 1}, in: []byte("hi"), out: []byte{2, 'h', 'i'}},
+		{name: "8 bytes little endian", codec: LengthFieldCodec{FieldLength: 8, ByteOrder: binary.LittleEndian}, in: []byte("hi"), out: []byte{2, 0, 0, 0, 0, 0, 0, 0, 'h', 'i'}},
+		{name: "field counts itself", codec: LengthFieldCodec{FieldLength: 2, Adjustment: -2}, in: []byte("hi"), out: []byte{0, 4, 'h', 'i'}},
+		{name: "negative field", codec: LengthFieldCodec{Adjustment: 3}, in: []byte("hi"), err: ErrInvalidLength},
+		{name: "field overflow", codec: LengthFieldCodec{FieldLength: 1}, in: make([]byte, 256), err: ErrFrameTooLarge},
+		{name: "over max", codec: LengthFieldCodec{MaxFrameLength: 1}, in: []byte("hi"), err: ErrFrameTooLarge},
+		{name: "invalid width", codec: LengthFieldCodec{FieldLength: 5}, in: []byte("hi"), err: ErrInvalidFieldLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.codec.Encode(nil, tt.in)
+			if err != tt.err || !bytes.Equal(out, tt.out) {
+				t.Fatalf("Encode = %v, %v, want %v, %v", out, err, tt.out, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			frame, n, err := tt.codec.Decode(nil, out)
+			if err != nil || n != len(out) || !bytes.Equal(frame, out) {
+				t.Fatalf("Decode(Encode) = %v, %d, %v", frame, n, err)
+			}
+		})
+	}
+}
+
+func TestLengthFieldEncodeBuffers(t *testing.T) {
+	lf := &LengthFieldCodec{FieldLength: 2}
+	out, err := lf.EncodeBuffers(nil, net.Buffers{[]byte("he"), []byte("llo")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 3 || !bytes.Equal(out[0], []byte{0, 5}) {
+		t.Fatalf("EncodeBuffers = %q", out)
+	}
+}
+
+func TestLengthFieldSplitReads(t *testing.T) {
+	lf := &LengthFieldCodec{Strip: true}
+	stream, _ := lf.Encode(nil, []byte("hello"))
+	second, _ := lf.Encode(nil, []byte("world"))
+	stream = append(stream, second...)
+
+	var buf []byte
+	var frames []string
+	for _, b := range stream {
+		buf = append(buf, b)
+		for {
+			frame, n, err := lf.Decode(nil, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n == 0 {
+				break
+			}
+			frames = append(frames, string(frame))
+			buf = buf[n:]
+		}
+	}
+	if len(frames) != 2 || frames[0] != "hello" || frames[1] != "world" || len(buf) != 0 {
+		t.Fatalf("frames = %q, rest %q", frames, buf)
+	}
+}
+
+func TestLengthFieldEncodeBuffersHeaderSize(t *testing.T) {
+	lf := &LengthFieldCodec{}
+	out, err := lf.EncodeBuffers(nil, net.Buffers{make([]byte, 1<<20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cap(out[0]) != 4 {
+		t.Fatalf("header capacity = %d, want 4", cap(out[0]))
+	}
+}
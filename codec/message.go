@@ -0,0 +1,88 @@
+package codec
+
+import (
+	"sync"
+
+	"github.com/dreamans/evnio"
+)
+
+// MessageHandler receives the messages of type T decoded by a MessageAdapter.
+type MessageHandler[T any] interface {
+	OnOpen(*MessageConn[T])
+	OnMessage(c *MessageConn[T], msg T)
+	OnClose(*MessageConn[T])
+	// OnError is called with codec and Unmarshal errors, the connection is then closed.
+	OnError(*MessageConn[T], error)
+}
+
+// MessageConn is a connection sending messages of type T.
+type MessageConn[T any] struct {
+	evnio.Connection
+	marshal func(msg T) ([]byte, error)
+}
+
+// SendMessage marshals msg on the calling goroutine and sends it.
+func (mc *MessageConn[T]) SendMessage(msg T) error {
+	data, err := mc.marshal(msg)
+	if err != nil {
+		return err
+	}
+	return mc.Send(data, evnio.ActionNone)
+}
+
+// MessageAdapter is a ConnectionHandler turning frames into messages of type T for Handler,
+// use it with a Codec such as VarintCodec.
+type MessageAdapter[T any] struct {
+	Handler MessageHandler[T]
+	// Marshal encodes a message given to SendMessage.
+	Marshal func(msg T) ([]byte, error)
+	// Unmarshal decodes a frame, data is only valid until it returns.
+	Unmarshal func(data []byte) (T, error)
+
+	connections sync.Map
+}
+
+func (ma *MessageAdapter[T]) OnOpen(c evnio.Connection) {
+	mc := &MessageConn[T]{
+		Connection: c,
+		marshal:    ma.Marshal,
+	}
+	ma.connections.Store(c.UniqID(), mc)
+	ma.Handler.OnOpen(mc)
+}
+
+func (ma *MessageAdapter[T]) OnMessage(c evnio.Connection, data []byte) {
+	mc, ok := ma.conn(c)
+	if !ok {
+		_ = c.Close()
+		return
+	}
+	msg, err := ma.Unmarshal(data)
+	if err != nil {
+		ma.Handler.OnError(mc, err)
+		_ = c.Close()
+		return
+	}
+	ma.Handler.OnMessage(mc, msg)
+}
+
+func (ma *MessageAdapter[T]) OnClose(c evnio.Connection) {
+	if mc, ok := ma.conn(c); ok {
+		ma.connections.Delete(c.UniqID())
+		ma.Handler.OnClose(mc)
+	}
+}
+
+func (ma *MessageAdapter[T]) OnError(c evnio.Connection, err error) {
+	if mc, ok := ma.conn(c); ok {
+		ma.Handler.OnError(mc, err)
+	}
+}
+
+func (ma *MessageAdapter[T]) conn(c evnio.Connection) (*MessageConn[T], bool) {
+	mc, ok := ma.connections.Load(c.UniqID())
+	if !ok {
+		return nil, false
+	}
+	return mc.(*MessageConn[T]), true
+}
@@ -0,0 +1,112 @@
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/dreamans/evnio"
+)
+
+// testConn is the part of a connection MessageAdapter uses.
+type testConn struct {
+	evnio.Connection
+	sent   [][]byte
+	closed bool
+}
+
+func (c *testConn) UniqID() uint64 { return 1 }
+
+func (c *testConn) Send(data []byte, action evnio.Action) error {
+	c.sent = append(c.sent, data)
+	return nil
+}
+
+func (c *testConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+type point struct {
+	X, Y int
+}
+
+type recordHandler struct {
+	conn   *MessageConn[point]
+	msgs   []point
+	errs   []error
+	closed bool
+}
+
+func (h *recordHandler) OnOpen(mc *MessageConn[point])             { h.conn = mc }
+func (h *recordHandler) OnMessage(mc *MessageConn[point], m point) { h.msgs = append(h.msgs, m) }
+func (h *recordHandler) OnClose(mc *MessageConn[point])            { h.closed = true }
+func (h *recordHandler) OnError(mc *MessageConn[point], err error) { h.errs = append(h.errs, err) }
+
+func TestMessageAdapterRoundTrip(t *testing.T) {
+	h := &recordHandler{}
+	ma := &MessageAdapter[point]{
+		Handler: h,
+		Marshal: func(p point) ([]byte, error) {
+			if p.X < 0 {
+				return nil, errors.New("negative X")
+			}
+			return json.Marshal(p)
+		},
+		Unmarshal: func(data []byte) (point, error) {
+			var p point
+			err := json.Unmarshal(data, &p)
+			return p, err
+		},
+	}
+	vc := &VarintCodec{MaxFrameLength: 64}
+	c := &testConn{}
+
+	ma.OnOpen(c)
+	if h.conn == nil {
+		t.Fatal("OnOpen not forwarded")
+	}
+	if err := h.conn.SendMessage(point{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.conn.SendMessage(point{-1, 0}); err == nil {
+		t.Fatal("SendMessage of an unmarshalable value succeeded")
+	}
+	if len(c.sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(c.sent))
+	}
+
+	// what the connection would write, read back through the codec
+	wire, err := vc.Encode(c, c.sent[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, n, err := vc.Decode(c, wire)
+	if err != nil || n != len(wire) {
+		t.Fatalf("Decode = %d, %v", n, err)
+	}
+	ma.OnMessage(c, frame)
+	if len(h.msgs) != 1 || h.msgs[0] != (point{1, 2}) {
+		t.Fatalf("messages = %v", h.msgs)
+	}
+
+	ma.OnMessage(c, []byte("{"))
+	if len(h.errs) != 1 || !c.closed {
+		t.Fatalf("Unmarshal error: errs %v, closed %v", h.errs, c.closed)
+	}
+	codecErr := errors.New("codec failed")
+	ma.OnError(c, codecErr)
+	if len(h.errs) != 2 || h.errs[1] != codecErr {
+		t.Fatalf("errs = %v", h.errs)
+	}
+
+	ma.OnClose(c)
+	if !h.closed {
+		t.Fatal("OnClose not forwarded")
+	}
+	c.closed = false
+	ma.OnMessage(c, frame)
+	if len(h.msgs) != 1 || !c.closed {
+		t.Fatal("message after close was delivered")
+	}
+}
@@ -0,0 +1,77 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+
+	"github.com/dreamans/evnio"
+)
+
+// VarintCodec frames messages behind an unsigned varint length, the convention of
+// protobuf's writeDelimitedTo and parseDelimitedFrom.
+type VarintCodec struct {
+	// MaxFrameLength bounds the payload length, zero is unlimited.
+	MaxFrameLength int
+}
+
+func (vc *VarintCodec) Decode(c evnio.Connection, data []byte) ([]byte, int, error) {
+	length, size := binary.Uvarint(data)
+	if size == 0 {
+		if len(data) >= binary.MaxVarintLen64 {
+			return nil, 0, ErrInvalidLength
+		}
+		return nil, 0, nil
+	}
+	if size < 0 {
+		return nil, 0, ErrInvalidLength
+	}
+	if (vc.MaxFrameLength > 0 && length > uint64(vc.MaxFrameLength)) || length > maxInt-uint64(size) {
+		return nil, 0, ErrFrameTooLarge
+	}
+
+	total := size + int(length)
+	if len(data) < total {
+		return nil, 0, nil
+	}
+	return data[size:total], total, nil
+}
+
+func (vc *VarintCodec) Encode(c evnio.Connection, data []byte) ([]byte, error) {
+	header, err := vc.header(len(data), len(data))
+	if err != nil {
+		return nil, err
+	}
+	return append(header, data...), nil
+}
+
+func (vc *VarintCodec) EncodeBuffers(c evnio.Connection, buffers net.Buffers) (net.Buffers, error) {
+	n := 0
+	for _, b := range buffers {
+		n += len(b)
+	}
+	header, err := vc.header(n, 0)
+	if err != nil {
+		return nil, err
+	}
+	return append(net.Buffers{header}, buffers...), nil
+}
+
+// header returns the varint length of a payload of n bytes, with room for room more bytes.
+func (vc *VarintCodec) header(n, room int) ([]byte, error) {
+	if vc.MaxFrameLength > 0 && n > vc.MaxFrameLength {
+		return nil, ErrFrameTooLarge
+	}
+	header := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+room)
+	return header[:binary.PutUvarint(header, uint64(n))], nil
+}
+
+// UnPacket implements evnio.Protocol.
+func (vc *VarintCodec) UnPacket(c evnio.Connection, buffer *bytes.Buffer) []byte {
+	return unPacket(vc, c, buffer)
+}
+
+// Packet implements evnio.Protocol.
+func (vc *VarintCodec) Packet(c evnio.Connection, data []byte) []byte {
+	return packet(vc, c, data)
+}
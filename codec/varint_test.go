@@ -0,0 +1,69 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestVarintDecode(t *testing.T) {
+	overlong := bytes.Repeat([]byte{0x80}, 10)
+	overflow := append(bytes.Repeat([]byte{0xff}, 9), 0x02)
+	maxUint64 := append(bytes.Repeat([]byte{0xff}, 9), 0x01)
+
+	tests := []struct {
+		name  string
+		codec VarintCodec
+		in    []byte
+		frame []byte
+		n     int
+		err   error
+	}{
+		{name: "frame", in: []byte{2, 'h', 'i', 'x'}, frame: []byte("hi"), n: 3},
+		{name: "empty frame", in: []byte{0}, frame: []byte{}, n: 1},
+		{name: "two byte length", in: append([]byte{0xac, 0x02}, make([]byte, 300)...), frame: make([]byte, 300), n: 302},
+		{name: "truncated prefix", in: []byte{0xac}},
+		{name: "truncated long prefix", in: bytes.Repeat([]byte{0x80}, 9)},
+		{name: "partial payload", in: []byte{3, 'h', 'i'}},
+		{name: "overlong", in: overlong, err: ErrInvalidLength},
+		{name: "overflow", in: overflow, err: ErrInvalidLength},
+		{name: "over int", in: maxUint64, err: ErrFrameTooLarge},
+		{name: "at max", codec: VarintCodec{MaxFrameLength: 2}, in: []byte{2, 'h', 'i'}, frame: []byte("hi"), n: 3},
+		{name: "over max", codec: VarintCodec{MaxFrameLength: 2}, in: []byte{3}, err: ErrFrameTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, n, err := tt.codec.Decode(nil, tt.in)
+			if err != tt.err || n != tt.n || !bytes.Equal(frame, tt.frame) {
+				t.Fatalf("Decode = %v, %d, %v, want %v, %d, %v", frame, n, err, tt.frame, tt.n, tt.err)
+			}
+		})
+	}
+}
+
+func TestVarintEncode(t *testing.T) {
+	vc := &VarintCodec{MaxFrameLength: 300}
+	out, err := vc.Encode(nil, make([]byte, 300))
+	if err != nil || !bytes.Equal(out[:2], []byte{0xac, 0x02}) || len(out) != 302 {
+		t.Fatalf("Encode = %v..., %d bytes, %v", out[:2], len(out), err)
+	}
+	if _, err := vc.Encode(nil, make([]byte, 301)); err != ErrFrameTooLarge {
+		t.Fatalf("Encode over max = %v, want ErrFrameTooLarge", err)
+	}
+	frames := decodeStream(t, vc, append(out, 1, 'x'))
+	if len(frames) != 2 || len(frames[0]) != 300 || frames[1] != "x" {
+		t.Fatalf("decoded %d frames", len(frames))
+	}
+}
+
+func TestVarintEncodeBuffersHeaderSize(t *testing.T) {
+	vc := &VarintCodec{}
+	out, err := vc.EncodeBuffers(nil, net.Buffers{make([]byte, 1<<20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out[0], []byte{0x80, 0x80, 0x40}) || cap(out[0]) > binary.MaxVarintLen64 {
+		t.Fatalf("header = %v with capacity %d", out[0], cap(out[0]))
+	}
+}
@@ -0,0 +1,124 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+var errBadFrame = errors.New("bad frame")
+
+// testCodec frames lines, a line starting with "!" is malformed and one starting
+// with "?" claims more bytes than it was given.
+type testCodec struct{}
+
+func (testCodec) Decode(c Connection, data []byte) ([]byte, int, error) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil, 0, nil
+	}
+	switch data[0] {
+	case '!':
+		return nil, 0, errBadFrame
+	case '?':
+		return data, len(data) + 1, nil
+	}
+	return data[:i], i + 1, nil
+}
+
+func (testCodec) Encode(c Connection, data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, []byte("!")) {
+		return nil, errBadFrame
+	}
+	return append(data, '\n'), nil
+}
+
+type codecErrorHandler struct {
+	nopHandler
+	closed chan error
+}
+
+func (h *codecErrorHandler) OnMessage(c Connection, data []byte) {
+	// "echo !x" answers "!x", which can't be encoded
+	_ = c.Send(bytes.TrimPrefix(data, []byte("echo ")), ActionNone)
+}
+
+func (h *codecErrorHandler) OnError(c Connection, err error) {
+	_ = c.Send([]byte("error: "+err.Error()), ActionNone)
+}
+
+func (h *codecErrorHandler) OnClose(c Connection) {
+	h.closed <- c.CloseReason()
+}
+
+func TestCodecErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  string
+		err  error
+	}{
+		{name: "decode", in: "hi\n!oops\nlost\n", out: "hi\nerror: bad frame\n", err: errBadFrame},
+		{name: "encode", in: "echo !x\n", out: "error: bad frame\n", err: errBadFrame},
+		{name: "overrun", in: "?\n", out: "error: " + errCodecOverrun.Error() + "\n", err: errCodecOverrun},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &codecErrorHandler{closed: make(chan error, 1)}
+			srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(1).SetCodec(testCodec{}).SetHandler(h))
+			go srv.Start()
+			defer srv.Shutdown(context.Background())
+
+			nc, err := net.Dial("tcp", listenAddr(t, srv).String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer nc.Close()
+			if _, err := nc.Write([]byte(tt.in)); err != nil {
+				t.Fatal(err)
+			}
+
+			// what OnError sends is flushed before the connection is closed
+			_ = nc.SetReadDeadline(time.Now().Add(time.Second))
+			out, err := ioutil.ReadAll(nc)
+			if err != nil || string(out) != tt.out {
+				t.Fatalf("read %q, %v, want %q", out, err, tt.out)
+			}
+			select {
+			case err := <-h.closed:
+				if err != tt.err {
+					t.Fatalf("CloseReason() = %v, want %v", err, tt.err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("connection not closed")
+			}
+		})
+	}
+}
+
+func TestCodecErrorWithoutErrorHandler(t *testing.T) {
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(1).SetCodec(testCodec{}).SetHandler(echoHandler{}))
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+
+	nc, err := net.Dial("tcp", listenAddr(t, srv).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	if _, err := nc.Write([]byte("!oops\n")); err != nil {
+		t.Fatal(err)
+	}
+	// the connection is closed straight away
+	_ = nc.SetReadDeadline(time.Now().Add(time.Second))
+	out, err := ioutil.ReadAll(nc)
+	if err != nil || len(out) != 0 {
+		t.Fatalf("read %q, %v, want EOF", out, err)
+	}
+}
@@ -3,20 +3,36 @@ package evnio
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"net"
+	"os"
 	"sync"
+	"time"
 )
 
-var ErrConnectionClosed = errors.New("connection closed")
+var (
+	ErrConnectionClosed = errors.New("connection closed")
+	ErrIdleTimeout      = errors.New("evnio: idle timeout")
+	ErrReadTimeout      = errors.New("evnio: read timeout")
+	ErrWriteTimeout     = errors.New("evnio: write timeout")
+	ErrNotUnixSocket    = errors.New("evnio: not a unix domain socket")
+	ErrWouldBlock       = errors.New("evnio: send would block, queued data over high water mark")
+
+	ErrTLSHandshakeTimeout = errors.New("evnio: tls handshake timeout")
+)
 
 const (
 	ConnectFdContextKey = "connect-fd-context-key"
 )
 
 type Connection interface {
+	// UniqID returns an ID that is never reused by another connection of the process.
 	UniqID() uint64
 
+	// Fd returns the socket descriptor, the kernel reuses it once the connection is closed.
+	Fd() int
+
 	RemoteAddr() net.Addr
 
 	LocalAddr() net.Addr
@@ -25,28 +41,98 @@ type Connection interface {
 
 	SetContext(context.Context)
 
+	// Send encodes the buffer with the Codec on the connection's loop and queues it, a copy
+	// is kept when the Codec returns the buffer as is. Unlike SendBuffers, the buffer may be
+	// reused once the loop has encoded it, and a frame given to OnMessage may be sent back.
 	Send([]byte, Action) error
 
+	// SendBuffers queues the buffers to be written with writev, they must not be modified until written.
+	SendBuffers(net.Buffers, Action) error
+
+	// SendFile queues length bytes of f from offset, or up to EOF if length <= 0, to be written with sendfile(2).
+	// The bytes are not passed through Protocol.Packet and f may be closed as soon as SendFile returns.
+	// They are read from the file as the socket drains rather than held in memory, so they don't count
+	// toward the water marks and SendFile never returns ErrWouldBlock.
+	SendFile(f *os.File, offset, length int64) error
+
+	// SetWaterMarks overrides Options.LowWaterMark and Options.HighWaterMark for this connection.
+	SetWaterMarks(low, high int)
+
+	// PauseRead stops reading from the peer and delivering buffered messages until ResumeRead,
+	// unread data is left in the kernel so the peer's sends back up. The read timeout is suspended.
+	PauseRead() error
+
+	ResumeRead() error
+
+	// SetReadDeadline closes the connection with ErrReadTimeout once t has passed, a zero t disables it.
+	SetReadDeadline(t time.Time) error
+
+	// SetWriteDeadline closes the connection with ErrWriteTimeout if queued data is still unwritten at t.
+	SetWriteDeadline(t time.Time) error
+
+	// PeerCred returns the credentials of the process on the other end of a unix domain socket.
+	// Only Linux supports it, other systems return ErrNotSupported.
+	PeerCred() (*PeerCred, error)
+
+	// TLSConnectionState returns the negotiated TLS parameters, such as the ALPN protocol and
+	// peer certificates, it reports false until a TLS handshake has completed.
+	TLSConnectionState() (tls.ConnectionState, bool)
+
+	// CloseReason returns why the connection was closed, or nil while it is open.
+	CloseReason() error
+
 	Close() error
 }
 
+type PeerCred struct {
+	Pid int32
+	Uid uint32
+	Gid uint32
+}
+
 type ConnectionHandler interface {
 	OnOpen(c Connection)
 	OnMessage(c Connection, data []byte)
 	OnClose(c Connection)
 }
 
+// ShutdownHandler may be implemented by a ConnectionHandler to be notified on the
+// connection's loop when the server starts shutting down, data sent from OnShutdown is flushed before close.
+type ShutdownHandler interface {
+	OnShutdown(c Connection)
+}
+
+// HighWaterMarkHandler may be implemented by a ConnectionHandler to learn on the loop
+// goroutine that Send started refusing data with ErrWouldBlock.
+type HighWaterMarkHandler interface {
+	OnHighWaterMark(c Connection, buffered int)
+}
+
+// WritableHandler may be implemented by a ConnectionHandler to learn on the loop
+// goroutine that queued data fell to the low water mark after Send refused data.
+type WritableHandler interface {
+	OnWritable(c Connection)
+}
+
 type defaultConnectionHandler struct{}
 
 func (*defaultConnectionHandler) OnOpen(c Connection)                 {}
 func (*defaultConnectionHandler) OnMessage(c Connection, data []byte) {}
 func (*defaultConnectionHandler) OnClose(c Connection)                {}
 
-var connBufferPool = NewBufferPoll()
+var connUniqueIncr uint64
+
+var connBufferPool = newBufferPool()
 
 func NewBufferPoll() (pool sync.Pool) {
-	pool.New = func() interface{} {
-		return &bytes.Buffer{}
-	}
-	return pool
+	pool.New = newBuffer
+	return
+}
+
+func newBufferPool() *sync.Pool {
+	return &sync.Pool{New: newBuffer}
+}
+
+func newBuffer() interface{} {
+	return &bytes.Buffer{}
 }
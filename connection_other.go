@@ -5,9 +5,12 @@ package evnio
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"net"
+	"os"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/dreamans/evnio/util"
 
@@ -15,34 +18,32 @@ import (
 )
 
 type conn struct {
-	mu         sync.Mutex
-	rw         net.Conn
-	handler    ConnectionHandler
-	protocol   Protocol
-	closed     util.AtomicBool
-	cancelCtx  context.CancelFunc
-	readBuf    *bytes.Buffer
-	writeQueue chan []byte
-	action     Action
-	uniqID     uint64
+	mu          sync.Mutex
+	rw          net.Conn
+	handler     ConnectionHandler
+	codec       Codec
+	closed      util.AtomicBool
+	closeErr    error
+	cancelCtx   context.CancelFunc
+	readBuf     *bytes.Buffer
+	writeQueue  chan []byte
+	action      Action
+	uniqID      uint64
+	closeHooks  []func()
+	memberships memberships
 }
 
-var connUniqueIncr uint64
-
-func newConnection(rw net.Conn, pcol Protocol, handler ConnectionHandler) *conn {
+func newConnection(rw net.Conn, opts *Options) *conn {
 	c := &conn{
 		rw:         rw,
 		readBuf:    connBufferPool.Get().(*bytes.Buffer),
 		writeQueue: make(chan []byte, 16),
-		handler:    handler,
-		protocol:   pcol,
+		handler:    opts.Handler,
+		codec:      newCodec(opts),
 		action:     ActionNone,
 		uniqID:     atomic.AddUint64(&connUniqueIncr, 1),
 	}
-	if pcol == nil {
-		c.protocol = &defaultProtocol{}
-	}
-	if handler == nil {
+	if c.handler == nil {
 		c.handler = &defaultConnectionHandler{}
 	}
 	c.readBuf.Reset()
@@ -84,21 +85,89 @@ func (c *conn) Send(buffer []byte, action Action) error {
 	return nil
 }
 
+func (c *conn) SendBuffers(buffers net.Buffers, action Action) error {
+	return c.Send(bytes.Join(buffers, nil), action)
+}
+
+func (c *conn) SendFile(f *os.File, offset, length int64) error {
+	if length <= 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		length = fi.Size() - offset
+	}
+	if length <= 0 {
+		return nil
+	}
+	buf := make([]byte, length)
+	if _, err := f.ReadAt(buf, offset); err != nil {
+		return err
+	}
+	return c.Send(buf, ActionNone)
+}
+
+func (c *conn) SetWaterMarks(low, high int) {
+}
+
+func (c *conn) PauseRead() error {
+	return ErrNotSupported
+}
+
+func (c *conn) ResumeRead() error {
+	return ErrNotSupported
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	return c.rw.SetReadDeadline(t)
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	return c.rw.SetWriteDeadline(t)
+}
+
+func (c *conn) PeerCred() (*PeerCred, error) {
+	if _, ok := c.rw.(*net.UnixConn); !ok {
+		return nil, ErrNotUnixSocket
+	}
+	return nil, ErrNotSupported
+}
+
+func (c *conn) TLSConnectionState() (tls.ConnectionState, bool) {
+	if tc, ok := c.rw.(*tls.Conn); ok {
+		cs := tc.ConnectionState()
+		return cs, cs.HandshakeComplete
+	}
+	return tls.ConnectionState{}, false
+}
+
+func (c *conn) CloseReason() error {
+	if !c.closed.IsSet() {
+		return nil
+	}
+	return c.closeErr
+}
+
 func (c *conn) Close() error {
 	if c.closed.IsSet() {
 		return ErrConnectionClosed
 	}
-	return c.handleClose()
+	return c.handleClose(ErrConnectionClosed)
 }
 
-func (c *conn) handleClose() error {
+func (c *conn) handleClose(reason error) error {
 	c.mu.Lock()
 	defer c.mu.Lock()
 
 	if !c.closed.IsSet() {
+		c.closeErr = reason
 		c.closed.Set()
 		c.cancelCtx()
+		c.leaveGroups()
 		c.handler.OnClose(c)
+		for _, fn := range c.closeHooks {
+			fn()
+		}
 		connBufferPool.Put(c.readBuf)
 
 		evlog.Debugf("[HandleClose]: loc %s <-x-> remote %s", c.LocalAddr(), c.RemoteAddr())
@@ -108,6 +177,13 @@ func (c *conn) handleClose() error {
 	return nil
 }
 
+func (c *conn) codecError(err error) {
+	if h, ok := c.handler.(ErrorHandler); ok {
+		h.OnError(c, err)
+	}
+	_ = c.handleClose(err)
+}
+
 func (c *conn) accept(ctx context.Context) {
 	readerCtx, _ := context.WithCancel(ctx)
 	go c.readAndWait(readerCtx)
@@ -126,7 +202,7 @@ func (c *conn) readAndWait(ctx context.Context) {
 		default:
 		}
 		if err != nil {
-			_ = c.handleClose()
+			_ = c.handleClose(err)
 			return
 		}
 
@@ -134,8 +210,12 @@ func (c *conn) readAndWait(ctx context.Context) {
 
 		c.readBuf.Write(buf[:n])
 		for {
-			data := c.protocol.UnPacket(c, c.readBuf)
-			if len(data) == 0 {
+			data, ok, err := nextFrame(c.codec, c, c.readBuf)
+			if err != nil {
+				c.codecError(err)
+				return
+			}
+			if !ok {
 				break
 			}
 			c.handler.OnMessage(c, data)
@@ -147,14 +227,18 @@ func (c *conn) writeAndWait(ctx context.Context) {
 	for {
 		select {
 		case data := <-c.writeQueue:
-			packData := c.protocol.Packet(c, data)
+			packData, err := c.codec.Encode(c, data)
+			if err != nil {
+				c.codecError(err)
+				return
+			}
 			for {
 				n, err := c.rw.Write(packData)
 
 				evlog.Debugf("[HandleWrite]: loc %s <- remote %s, len {%d}, data {%v}", c.LocalAddr(), c.RemoteAddr(), n, packData[:n])
 
 				if err != nil {
-					_ = c.handleClose()
+					_ = c.handleClose(err)
 					return
 				}
 				if n == len(packData) {
@@ -5,9 +5,13 @@ package evnio
 import (
 	"bytes"
 	"context"
-	"fmt"
+	"crypto/tls"
+	"io"
 	"net"
+	"os"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/dreamans/evnio/util"
 
@@ -15,50 +19,140 @@ import (
 	"github.com/dreamans/evnio/poller"
 )
 
+const maxSendfileSize = 4 << 20
+
+// sendfile is replaced by tests to exercise the pread fallback.
+var sendfile = syscall.Sendfile
+
 type conn struct {
-	fd         int
-	evLoop     *EventLoop
-	handler    ConnectionHandler
-	writeBuf   *bytes.Buffer
-	readBuf    *bytes.Buffer
-	protocol   Protocol
-	closed     util.AtomicBool
-	localAddr  net.Addr
-	remoteAddr net.Addr
-	ctx        context.Context
-	action     Action
-}
-
-func newConnection(fd int, evLoop *EventLoop, caddr net.Addr, saddr net.Addr, pcol Protocol, handler ConnectionHandler) *conn {
+	fd          int
+	uniqID      uint64
+	evLoop      *EventLoop
+	handler     ConnectionHandler
+	writeQueue  writeQueue
+	readBuf     *bytes.Buffer
+	codec       Codec
+	closed      util.AtomicBool
+	closeReason error
+	localAddr   net.Addr
+	remoteAddr  net.Addr
+	ctx         context.Context
+	action      Action
+	opened      bool
+	closeHooks  []func()
+	memberships memberships
+	registry    *registry
+	// dialed is the DialHandler of a dialed connection until it is told the dial's outcome.
+	dialed   DialHandler
+	tlsState *tlsState
+	// proxyStart is set while a PROXY protocol header is expected and opens the connection once it is read.
+	proxyStart func()
+	proxyMode  ProxyProtocolMode
+	proxyTimer *Timer
+
+	// drainErr closes the connection once its queued writes are flushed.
+	drainErr error
+	// readDone stops reading after a Codec failed.
+	readDone bool
+
+	readLimiter    rateLimiter
+	messageLimiter rateLimiter
+	// throttled is set while reading is paused until the rate limiters refill.
+	throttled     bool
+	throttleTimer *Timer
+	readPaused    util.AtomicBool
+	// pauseApplied is the readPaused state the loop last acted on.
+	pauseApplied bool
+
+	queued    int64
+	lowWater  int64
+	highWater int64
+	blocked   util.AtomicBool
+
+	idleTimeout   time.Duration
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
+	lastActive    time.Time
+	readExpire    time.Time
+	writeExpire   time.Time
+	readDeadline  time.Time
+	writeDeadline time.Time
+	deadlineAt    time.Time
+	deadlineTimer *Timer
+}
+
+func newConnection(fd int, evLoop *EventLoop, caddr net.Addr, saddr net.Addr, opts *Options) *conn {
 	c := &conn{
-		fd:         fd,
-		evLoop:     evLoop,
-		writeBuf:   connBufferPool.Get().(*bytes.Buffer),
-		readBuf:    connBufferPool.Get().(*bytes.Buffer),
-		remoteAddr: caddr,
-		localAddr:  saddr,
-		protocol:   pcol,
-		handler:    handler,
-		action:     ActionNone,
-	}
-	if pcol == nil {
-		c.protocol = &defaultProtocol{}
-	}
-	if handler == nil {
+		fd:           fd,
+		uniqID:       atomic.AddUint64(&connUniqueIncr, 1),
+		evLoop:       evLoop,
+		readBuf:      connBufferPool.Get().(*bytes.Buffer),
+		remoteAddr:   caddr,
+		localAddr:    saddr,
+		codec:        newCodec(opts),
+		handler:      opts.Handler,
+		action:       ActionNone,
+		lowWater:     int64(opts.LowWaterMark),
+		highWater:    int64(opts.HighWaterMark),
+		idleTimeout:  opts.IdleTimeout,
+		readTimeout:  opts.ReadTimeout,
+		writeTimeout: opts.WriteTimeout,
+
+		readLimiter:    newRateLimiter(newTokenBucket(opts.ReadLimit)),
+		messageLimiter: newRateLimiter(newTokenBucket(opts.MessageLimit)),
+	}
+	if c.handler == nil {
 		c.handler = &defaultConnectionHandler{}
 	}
 	c.ctx = context.WithValue(context.Background(), ConnectFdContextKey, fd)
 
-	c.writeBuf.Reset()
 	c.readBuf.Reset()
-	c.handler.OnOpen(c)
+
+	if c.idleTimeout > 0 || c.readTimeout > 0 {
+		now := time.Now()
+		c.lastActive = now
+		if c.readTimeout > 0 {
+			c.readExpire = now.Add(c.readTimeout)
+		}
+		evLoop.Trigger(c.scheduleDeadline)
+	}
 
 	evlog.Debugf("[NewConnection]: loc %s <--> remote %s", c.LocalAddr(), c.RemoteAddr())
 	return c
 }
 
+// open registers the connection with its server and hands it to the handler, it runs on
+// the loop once the fd is registered and any TLS handshake or PROXY header is done.
+func (c *conn) open() {
+	c.opened = true
+	if c.registry != nil {
+		c.registry.add(c)
+	}
+	c.handler.OnOpen(c)
+	c.dialDone(nil)
+}
+
+// dialDone reports a dialed connection to its DialHandler once it is open, or the
+// reason it was closed before.
+func (c *conn) dialDone(err error) {
+	fn := c.dialed
+	if fn == nil {
+		return
+	}
+	c.dialed = nil
+	if err != nil {
+		fn(nil, err)
+		return
+	}
+	fn(c, nil)
+}
+
 func (c *conn) UniqID() uint64 {
-	return uint64(c.fd)
+	return c.uniqID
+}
+
+func (c *conn) Fd() int {
+	return c.fd
 }
 
 func (c *conn) RemoteAddr() net.Addr {
@@ -84,32 +178,298 @@ func (c *conn) Send(buffer []byte, action Action) error {
 	if len(buffer) == 0 {
 		return nil
 	}
+	if err := c.reserve(len(buffer)); err != nil {
+		return err
+	}
+
 	c.evLoop.Trigger(func() {
 		if !c.closed.IsSet() {
-			c.writeBuf.Write(c.protocol.Packet(c, buffer))
-			c.action = action
-			if err := c.evLoop.EnableReadWrite(c.fd); err != nil {
-				evlog.Errorf("[evLoop.EnableReadWrite]: %s", err.Error())
+			c.packet(buffer, action, false)
+		}
+	})
+	return nil
+}
+
+// sendOnLoop is Send for callers already on the connection's loop goroutine that
+// own buffer and never modify it again, it is queued without a copy.
+func (c *conn) sendOnLoop(buffer []byte, action Action) error {
+	if c.closed.IsSet() {
+		return ErrConnectionClosed
+	}
+	if len(buffer) == 0 {
+		return nil
+	}
+	if err := c.reserve(len(buffer)); err != nil {
+		return err
+	}
+	c.packet(buffer, action, true)
+	return nil
+}
+
+// packet encodes a reserved buffer with the Codec and queues it. The queue keeps
+// what it is given until written, so unless the caller owns buffer it is copied
+// when the Codec returned it as is, e.g. a frame pointing into the read buffer.
+func (c *conn) packet(buffer []byte, action Action, owned bool) {
+	data, err := c.codec.Encode(c, buffer)
+	if err != nil {
+		c.unreserve(len(buffer))
+		c.codecError(err)
+		return
+	}
+	if !owned && len(data) > 0 && &data[0] == &buffer[0] {
+		data = append([]byte(nil), data...)
+	}
+	atomic.AddInt64(&c.queued, int64(len(data)-len(buffer)))
+	c.enqueue(action, data)
+}
+
+func (c *conn) SendBuffers(buffers net.Buffers, action Action) error {
+	if c.closed.IsSet() {
+		return ErrConnectionClosed
+	}
+	size := 0
+	for _, b := range buffers {
+		size += len(b)
+	}
+	if size == 0 {
+		return nil
+	}
+	if err := c.reserve(size); err != nil {
+		return err
+	}
+
+	c.evLoop.Trigger(func() {
+		if !c.closed.IsSet() {
+			packed, err := encodeBuffers(c.codec, c, buffers)
+			if err != nil {
+				c.unreserve(size)
+				c.codecError(err)
+				return
+			}
+			n := 0
+			for _, b := range packed {
+				n += len(b)
+			}
+			atomic.AddInt64(&c.queued, int64(n-size))
+			c.enqueue(action, packed...)
+		}
+	})
+	return nil
+}
+
+// reserve accounts size bytes against the high water mark before they are queued,
+// concurrent senders can't get past it together.
+func (c *conn) reserve(size int) error {
+	for {
+		queued := atomic.LoadInt64(&c.queued)
+		if high := atomic.LoadInt64(&c.highWater); high > 0 && queued > 0 && queued+int64(size) > high {
+			if c.blocked.TrySet() {
+				c.evLoop.Trigger(c.handleHighWaterMark)
 			}
+			return ErrWouldBlock
+		}
+		if atomic.CompareAndSwapInt64(&c.queued, queued, queued+int64(size)) {
+			return nil
+		}
+	}
+}
+
+func (c *conn) SendFile(f *os.File, offset, length int64) error {
+	if c.closed.IsSet() {
+		return ErrConnectionClosed
+	}
+	if length <= 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		length = fi.Size() - offset
+	}
+	if length <= 0 {
+		return nil
+	}
+	fd, err := util.DupCloseOnExec(int(f.Fd()))
+	if err != nil {
+		return err
+	}
+	fr := &fileRange{fd: fd, offset: offset, remain: length}
+
+	var push func()
+	push = func() {
+		if c.closed.IsSet() {
+			_ = syscall.Close(fd)
+			return
+		}
+		if c.deferUntilHandshake(push) {
+			return
+		}
+		pending := c.writeQueue.Len() > 0
+		c.writeQueue.pushFile(fr)
+		c.armWrite(pending, c.action)
+	}
+	c.evLoop.Trigger(push)
+	return nil
+}
+
+func (c *conn) enqueue(action Action, data ...[]byte) {
+	if c.deferUntilHandshake(func() { c.enqueue(action, data...) }) {
+		return
+	}
+	pending := c.writeQueue.Len() > 0
+	for _, b := range data {
+		if c.tlsState != nil {
+			c.writeQueue.pushPlain(b)
+		} else {
+			c.writeQueue.push(b)
+		}
+	}
+	c.armWrite(pending, action)
+}
+
+// enqueueSealed queues TLS records produced outside of Send, such as the handshake.
+func (c *conn) enqueueSealed(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	atomic.AddInt64(&c.queued, int64(len(b)))
+	pending := c.writeQueue.Len() > 0
+	c.writeQueue.pushSealed(b)
+	c.armWrite(pending, c.action)
+}
+
+// armWrite starts the write timeout when the queue stops being empty and waits for writability.
+func (c *conn) armWrite(pending bool, action Action) {
+	if !pending && c.writeQueue.Len() > 0 {
+		if c.writeTimeout > 0 {
+			c.writeExpire = time.Now().Add(c.writeTimeout)
 		}
+		c.scheduleDeadline()
+	} else if pending && action == c.action {
+		// already waiting for writability
+		return
+	}
+	c.action = action
+	c.updateEvents()
+}
+
+// updateEvents sets the poller interest from the connection state.
+func (c *conn) updateEvents() {
+	read := c.reading()
+	write := c.writeQueue.Len() > 0 || c.action != ActionNone || c.drainErr != nil
+	var err error
+	switch {
+	case read && write:
+		err = c.evLoop.EnableReadWrite(c.fd)
+	case read:
+		err = c.evLoop.EnableRead(c.fd)
+	case write:
+		err = c.evLoop.EnableWrite(c.fd)
+	default:
+		err = c.evLoop.Disable(c.fd)
+	}
+	if err != nil {
+		evlog.Errorf("[conn.updateEvents]: %s", err.Error())
+	}
+}
+
+func (c *conn) SetWaterMarks(low, high int) {
+	atomic.StoreInt64(&c.lowWater, int64(low))
+	atomic.StoreInt64(&c.highWater, int64(high))
+}
+
+func (c *conn) PauseRead() error {
+	if c.closed.IsSet() {
+		return ErrConnectionClosed
+	}
+	c.readPaused.Set()
+	c.evLoop.Trigger(c.applyReadPaused)
+	return nil
+}
+
+func (c *conn) ResumeRead() error {
+	if c.closed.IsSet() {
+		return ErrConnectionClosed
+	}
+	c.readPaused.Unset()
+	c.evLoop.Trigger(c.applyReadPaused)
+	return nil
+}
+
+// applyReadPaused brings the poller interest in line with the latest PauseRead or
+// ResumeRead, calls racing from other goroutines leave the flag set by the last one.
+func (c *conn) applyReadPaused() {
+	paused := c.readPaused.IsSet()
+	if c.closed.IsSet() || paused == c.pauseApplied {
+		return
+	}
+	c.pauseApplied = paused
+	if paused {
+		c.updateEvents()
+	} else {
+		c.resumeReading()
+	}
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	if c.closed.IsSet() {
+		return ErrConnectionClosed
+	}
+	c.evLoop.Trigger(func() {
+		c.readDeadline = t
+		c.scheduleDeadline()
+	})
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	if c.closed.IsSet() {
+		return ErrConnectionClosed
+	}
+	c.evLoop.Trigger(func() {
+		c.writeDeadline = t
+		c.scheduleDeadline()
 	})
 	return nil
 }
 
+func (c *conn) PeerCred() (*PeerCred, error) {
+	if _, ok := c.remoteAddr.(*net.UnixAddr); !ok {
+		return nil, ErrNotUnixSocket
+	}
+	if c.closed.IsSet() {
+		return nil, ErrConnectionClosed
+	}
+	return getPeerCred(c.fd)
+}
+
+func (c *conn) TLSConnectionState() (tls.ConnectionState, bool) {
+	if c.tlsState == nil || !c.tlsState.handshaked.IsSet() {
+		return tls.ConnectionState{}, false
+	}
+	return c.tlsState.ConnectionState(), true
+}
+
+func (c *conn) CloseReason() error {
+	if !c.closed.IsSet() {
+		return nil
+	}
+	return c.closeReason
+}
+
 func (c *conn) Close() error {
 	if c.closed.IsSet() {
 		return ErrConnectionClosed
 	}
 
 	c.evLoop.Trigger(func() {
-		c.handleClose(c.fd)
+		c.handleClose(c.fd, ErrConnectionClosed)
 	})
 	return nil
 }
 
 func (c *conn) EventHandler(fd int, events poller.Event) {
 	if events&poller.EventErr != 0 {
-		c.handleClose(fd)
+		c.handleClose(fd, io.EOF)
 		return
 	}
 	if events&poller.EventRead != 0 {
@@ -120,32 +480,74 @@ func (c *conn) EventHandler(fd int, events poller.Event) {
 	}
 }
 
-func (c *conn) handleClose(fd int) {
+func (c *conn) handleClose(fd int, reason error) {
 	if !c.closed.IsSet() {
+		c.closeReason = reason
 		c.closed.Set()
 
+		if c.deadlineTimer != nil {
+			c.deadlineTimer.Stop()
+		}
+		if c.throttleTimer != nil {
+			c.throttleTimer.Stop()
+		}
+		if c.proxyTimer != nil {
+			c.proxyTimer.Stop()
+		}
+
 		if err := c.evLoop.DelFdHandler(fd); err != nil {
 			evlog.Errorf("[evLoop.DelFdHandler]: %s", err.Error())
 		}
 
-		c.handler.OnClose(c)
+		c.leaveGroups()
+		if c.opened {
+			c.handler.OnClose(c)
+		} else {
+			c.dialDone(reason)
+		}
+		c.evLoop.releaseConn()
+		for _, fn := range c.closeHooks {
+			fn()
+		}
+		if c.tlsState != nil {
+			c.tlsState.close()
+			if reason == ErrConnectionClosed || reason == ErrServerClosed {
+				c.closeNotify(fd)
+			}
+		}
 		if err := syscall.Close(fd); err != nil {
 			evlog.Errorf("[syscall.Close]: %s", err.Error())
 		}
 
 		connBufferPool.Put(c.readBuf)
-		connBufferPool.Put(c.writeBuf)
+		c.writeQueue.reset()
 
 		evlog.Debugf("[HandleClose]: loc %s <-x-> remote %s", c.LocalAddr(), c.RemoteAddr())
 	}
 }
 
 func (c *conn) handleRead(fd int) {
+	if !c.reading() {
+		return
+	}
 	buf := c.evLoop.PacketBuf()
+	now := time.Now()
+	if len(c.readLimiter) > 0 {
+		allow := c.readLimiter.available(now)
+		if allow <= 0 {
+			c.throttle(c.readLimiter.wait(now))
+			return
+		}
+		if allow < len(buf) {
+			buf = buf[:allow]
+		}
+	}
 	n, err := syscall.Read(fd, buf)
 	if n == 0 || err != nil {
-		if err != syscall.EAGAIN {
-			c.handleClose(fd)
+		if err == nil {
+			c.handleClose(fd, io.EOF)
+		} else if err != syscall.EAGAIN {
+			c.handleClose(fd, err)
 		}
 		if err != nil {
 			evlog.Errorf("[syscall.Read]: %s", err.Error())
@@ -153,64 +555,390 @@ func (c *conn) handleRead(fd int) {
 		return
 	}
 
+	c.readLimiter.take(n, now)
+	if c.idleTimeout > 0 || c.readTimeout > 0 {
+		c.lastActive = now
+		if c.readTimeout > 0 {
+			c.readExpire = now.Add(c.readTimeout)
+		}
+	}
+
 	evlog.Debugf("[HandleRead]: loc %s <- remote %s, len {%d}", c.LocalAddr(), c.RemoteAddr(), n)
 
-	c.readBuf.Write(buf[:n])
-	c.protocolUnPacket(c.readBuf)
+	if c.proxyStart != nil {
+		c.readProxyHeader(buf[:n])
+		return
+	}
+	c.receive(buf[:n])
+}
+
+func (c *conn) receive(data []byte) {
+	if c.tlsState != nil {
+		c.tlsState.tr.feed(data)
+		if c.tlsState.handshaked.IsSet() {
+			c.readTLS()
+		} else {
+			c.stepHandshake()
+		}
+		return
+	}
+
+	c.readBuf.Write(data)
+	c.decodeFrames(c.readBuf)
+}
+
+// expectProxyHeader defers start until the PROXY protocol header has been read, the
+// connection is closed if it is still missing after timeout.
+func (c *conn) expectProxyHeader(mode ProxyProtocolMode, timeout time.Duration, start func()) {
+	if timeout <= 0 {
+		timeout = defaultProxyHeaderTimeout
+	}
+	c.proxyMode = mode
+	c.proxyStart = start
+	c.proxyTimer = c.evLoop.AfterFunc(timeout, func() {
+		if !c.closed.IsSet() && c.proxyStart != nil {
+			c.handleClose(c.fd, ErrProxyHeaderTimeout)
+		}
+	})
+}
+
+func (c *conn) readProxyHeader(data []byte) {
+	c.readBuf.Write(data)
+	h, n, err := parseProxyHeader(c.readBuf.Bytes())
+	if err == errNoProxyHeader && c.proxyMode == ProxyProtocolOptional {
+		err = nil
+	} else if err == errNoProxyHeader {
+		err = ErrProxyHeader
+	} else if err == nil && h == nil {
+		return
+	}
+	if err != nil {
+		c.handleClose(c.fd, err)
+		return
+	}
+
+	if h != nil {
+		c.readBuf.Next(n)
+		if src, dst, ok := h.streamAddrs(); ok {
+			c.remoteAddr, c.localAddr = src, dst
+		}
+		c.ctx = context.WithValue(c.ctx, ProxyHeaderContextKey, h)
+	}
+	rest := append([]byte(nil), c.readBuf.Bytes()...)
+	c.readBuf.Reset()
+
+	start := c.proxyStart
+	c.proxyStart = nil
+	c.proxyTimer.Stop()
+	c.proxyTimer = nil
+	start()
+	if len(rest) > 0 && !c.closed.IsSet() {
+		c.receive(rest)
+	}
 }
 
 func (c *conn) handleWrite(fd int) {
-	if c.writeBuf.Len() > 0 {
+	if c.writeQueue.Len() > 0 {
 		c.writeTo(fd)
 	} else if c.action != ActionNone {
 		c.actionTo(fd)
 	}
-	if c.writeBuf.Len() == 0 && c.action == ActionNone {
-		if err := c.evLoop.EnableRead(c.fd); err != nil {
-			evlog.Errorf("[evLoop.EnableRead]: %s", err.Error())
-		}
+	if c.closed.IsSet() {
+		return
+	}
+	if c.writeQueue.Len() == 0 && c.drainErr != nil {
+		c.handleClose(fd, c.drainErr)
+		return
+	}
+	if c.writeQueue.Len() == 0 && c.action == ActionNone {
+		c.updateEvents()
 	}
 }
 
 func (c *conn) writeTo(fd int) {
-	n, err := syscall.Write(fd, c.writeBuf.Bytes())
-	if err != nil {
-		if err == syscall.EAGAIN {
+	var n int
+	var err error
+	if c.tlsState != nil {
+		if err := c.sealHead(); err != nil {
+			c.handleClose(fd, err)
 			return
 		}
+	}
+	fr := c.writeQueue.headFile()
+	if fr != nil {
+		n, err = c.sendFile(fd, fr)
+	} else {
+		n, err = util.Writev(fd, c.writeQueue.buffers())
+	}
+	if err != nil && err != syscall.EAGAIN {
 		// write failed, remove EVFILT_WRITE
 		_ = c.evLoop.EnableRead(c.fd)
 
-		c.handleClose(fd)
-		evlog.Errorf("[syscall.Write]: %s", err.Error())
+		c.handleClose(fd, err)
+		evlog.Errorf("[conn.writeTo]: %s", err.Error())
+		return
+	}
+	if n <= 0 {
 		return
 	}
 
 	evlog.Debugf("[HandleWrite]: loc %s -> remote %s, len {%d}", c.LocalAddr(), c.RemoteAddr(), n)
 
-	if n == c.writeBuf.Len() {
-		c.writeBuf.Reset()
-	} else {
-		c.writeBuf.Next(n)
+	c.writeQueue.advance(n)
+	now := time.Now()
+	if c.writeQueue.Len() == 0 {
+		c.writeExpire = time.Time{}
+	} else if c.writeTimeout > 0 {
+		// a peer that keeps reading keeps the connection, however much is queued
+		c.writeExpire = now.Add(c.writeTimeout)
+	}
+	if c.idleTimeout > 0 {
+		c.lastActive = now
+	}
+	if fr != nil {
+		return
+	}
+
+	c.unreserve(n)
+}
+
+// unreserve releases n queued bytes and tells a blocked handler once the low water mark is reached.
+func (c *conn) unreserve(n int) {
+	queued := atomic.AddInt64(&c.queued, -int64(n))
+	if c.blocked.IsSet() && queued <= c.lowWaterMark() {
+		c.blocked.Unset()
+		if h, ok := c.handler.(WritableHandler); ok {
+			h.OnWritable(c)
+		}
+	}
+}
+
+// sendFile writes the head of a file range, falling back to pread and write
+// where sendfile(2) does not support the socket.
+func (c *conn) sendFile(fd int, fr *fileRange) (int, error) {
+	count := fr.remain
+	if count > maxSendfileSize {
+		count = maxSendfileSize
+	}
+	offset := fr.offset
+	n, err := sendfile(fd, fr.fd, &offset, int(count))
+	switch err {
+	case syscall.ENOSYS, syscall.EINVAL, syscall.EOPNOTSUPP, syscall.ENOTSOCK:
+		buf := c.evLoop.PacketBuf()
+		if int64(len(buf)) > count {
+			buf = buf[:count]
+		}
+		m, err := syscall.Pread(fr.fd, buf, fr.offset)
+		if err != nil {
+			return 0, err
+		}
+		if m == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return syscall.Write(fd, buf[:m])
+	case nil:
+		if n == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+	}
+	return n, err
+}
+
+func (c *conn) handleHighWaterMark() {
+	if c.closed.IsSet() {
+		return
+	}
+	if h, ok := c.handler.(HighWaterMarkHandler); ok {
+		h.OnHighWaterMark(c, int(atomic.LoadInt64(&c.queued)))
 	}
 }
 
+func (c *conn) lowWaterMark() int64 {
+	low := atomic.LoadInt64(&c.lowWater)
+	if low <= 0 {
+		low = atomic.LoadInt64(&c.highWater) / 2
+	}
+	return low
+}
+
 func (c *conn) actionTo(fd int) {
 	switch c.action {
 	default:
 		c.action = ActionNone
 	case ActionClose:
-		c.handleClose(fd)
+		c.handleClose(fd, ErrConnectionClosed)
 	}
-	fmt.Println(c.action)
 }
 
-func (c *conn) protocolUnPacket(buffer *bytes.Buffer) {
-	for {
-		data := c.protocol.UnPacket(c, buffer)
-		if len(data) == 0 {
+// shutdown notifies the handler and lets it queue final writes, the connection
+// is closed once everything queued up to that point has been flushed.
+func (c *conn) shutdown() {
+	if c.closed.IsSet() {
+		return
+	}
+	if h, ok := c.handler.(ShutdownHandler); ok && c.opened {
+		h.OnShutdown(c)
+	}
+	c.evLoop.Trigger(func() {
+		if c.closed.IsSet() {
+			return
+		}
+		c.drain(ErrServerClosed)
+	})
+}
+
+// drain closes the connection with reason once everything queued is flushed.
+func (c *conn) drain(reason error) {
+	c.drainErr = reason
+	if c.writeQueue.Len() == 0 {
+		c.handleClose(c.fd, reason)
+		return
+	}
+	c.updateEvents()
+}
+
+func (c *conn) decodeFrames(buffer *bytes.Buffer) {
+	for !c.closed.IsSet() && !c.readPaused.IsSet() {
+		if len(c.messageLimiter) > 0 {
+			if now := time.Now(); c.messageLimiter.available(now) <= 0 {
+				c.throttle(c.messageLimiter.wait(now))
+				return
+			}
+		}
+		data, ok, err := nextFrame(c.codec, c, buffer)
+		if err != nil {
+			c.codecError(err)
+			return
+		}
+		if !ok {
 			break
 		}
+		c.messageLimiter.take(1, time.Now())
 		c.handler.OnMessage(c, data)
 	}
 }
+
+// codecError reports a Codec failure to the handler and closes the connection.
+func (c *conn) codecError(err error) {
+	evlog.Debugf("[codecError]: loc %s <-x-> remote %s, %s", c.LocalAddr(), c.RemoteAddr(), err.Error())
+	h, ok := c.handler.(ErrorHandler)
+	if !ok || !c.opened {
+		c.handleClose(c.fd, err)
+		return
+	}
+	c.readDone = true
+	c.updateEvents()
+	h.OnError(c, err)
+	// the sends made by OnError are queued by then
+	c.evLoop.Trigger(func() {
+		if !c.closed.IsSet() {
+			c.drain(err)
+		}
+	})
+}
+
+// throttle pauses reading for d, frames already buffered are delivered once it ends.
+func (c *conn) throttle(d time.Duration) {
+	if c.throttled {
+		return
+	}
+	c.throttled = true
+	c.updateEvents()
+	c.throttleTimer = &Timer{fn: c.unthrottle}
+	c.evLoop.timers.add(c.throttleTimer, time.Now().Add(d))
+}
+
+func (c *conn) unthrottle() {
+	c.throttleTimer = nil
+	if c.closed.IsSet() {
+		return
+	}
+	c.throttled = false
+	c.resumeReading()
+}
+
+// resumeReading delivers frames buffered while reading was stopped and waits for data again.
+func (c *conn) resumeReading() {
+	if !c.reading() {
+		return
+	}
+	if c.readTimeout > 0 {
+		c.readExpire = time.Now().Add(c.readTimeout)
+		c.scheduleDeadline()
+	}
+	if c.readBuf.Len() > 0 {
+		c.decodeFrames(c.readBuf)
+	}
+	if c.reading() && !c.closed.IsSet() {
+		c.updateEvents()
+	}
+}
+
+// reading reports whether the connection waits for data from the peer, the read
+// timeout only runs while it does.
+func (c *conn) reading() bool {
+	return !c.throttled && !c.readPaused.IsSet() && !c.readDone
+}
+
+// scheduleDeadline keeps a single timer armed for the earliest pending deadline,
+// a later deadline is picked up when the armed timer fires.
+func (c *conn) scheduleDeadline() {
+	if c.closed.IsSet() {
+		return
+	}
+	deadlines := []time.Time{c.idleExpire(), c.readDeadline}
+	if c.reading() {
+		deadlines = append(deadlines, c.readExpire)
+	}
+	if c.writeQueue.Len() > 0 {
+		deadlines = append(deadlines, c.writeExpire, c.writeDeadline)
+	}
+
+	var at time.Time
+	for _, t := range deadlines {
+		if !t.IsZero() && (at.IsZero() || t.Before(at)) {
+			at = t
+		}
+	}
+	if at.IsZero() {
+		return
+	}
+	if c.deadlineTimer != nil {
+		if !c.deadlineAt.After(at) {
+			return
+		}
+		c.deadlineTimer.Stop()
+	}
+	c.deadlineAt = at
+	c.deadlineTimer = &Timer{fn: c.handleDeadline}
+	c.evLoop.timers.add(c.deadlineTimer, at)
+}
+
+func (c *conn) handleDeadline() {
+	c.deadlineTimer = nil
+	if c.closed.IsSet() {
+		return
+	}
+
+	now := time.Now()
+	expired := func(t time.Time) bool {
+		return !t.IsZero() && !now.Before(t)
+	}
+	switch {
+	case expired(c.idleExpire()):
+		c.handleClose(c.fd, ErrIdleTimeout)
+	case c.reading() && expired(c.readExpire), expired(c.readDeadline):
+		c.handleClose(c.fd, ErrReadTimeout)
+	case c.writeQueue.Len() > 0 && (expired(c.writeExpire) || expired(c.writeDeadline)):
+		c.handleClose(c.fd, ErrWriteTimeout)
+	default:
+		c.scheduleDeadline()
+	}
+}
+
+func (c *conn) idleExpire() time.Time {
+	if c.idleTimeout <= 0 {
+		return time.Time{}
+	}
+	return c.lastActive.Add(c.idleTimeout)
+}
@@ -0,0 +1,455 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type echoHandler struct {
+	nopHandler
+}
+
+func (echoHandler) OnMessage(c Connection, data []byte) {
+	_ = c.Send(data, ActionNone)
+}
+
+func TestSendEchoesReadBuffer(t *testing.T) {
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(1).SetHandler(echoHandler{}))
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+
+	nc, err := net.Dial("tcp", listenAddr(t, srv).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	_ = nc.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// the frames given to OnMessage point into the read buffer, which the
+	// next read overwrites while the echo of the previous one is still queued
+	want := make([]byte, 8<<20)
+	for i := range want {
+		want[i] = byte(i) ^ byte(i>>8) ^ byte(i>>16)
+	}
+	go func() {
+		_, _ = nc.Write(want)
+	}()
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(nc, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		i := 0
+		for got[i] == want[i] {
+			i++
+		}
+		t.Fatalf("echo differs from byte %d", i)
+	}
+}
+
+type pauseHandler struct {
+	nopHandler
+	opened   chan Connection
+	messages chan []byte
+}
+
+func (h *pauseHandler) OnOpen(c Connection) {
+	h.opened <- c
+}
+
+func (h *pauseHandler) OnMessage(c Connection, data []byte) {
+	h.messages <- append([]byte(nil), data...)
+}
+
+func TestPauseResumeReadLastCallWins(t *testing.T) {
+	h := &pauseHandler{opened: make(chan Connection, 1), messages: make(chan []byte, 16)}
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(1).SetHandler(h))
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+
+	nc, err := net.Dial("tcp", listenAddr(t, srv).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	c := <-h.opened
+
+	for i := 0; i < 100; i++ {
+		_ = c.PauseRead()
+		_ = c.ResumeRead()
+	}
+	_ = c.PauseRead()
+	if _, err := nc.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case b := <-h.messages:
+		t.Fatalf("read %q while paused", b)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_ = c.ResumeRead()
+	select {
+	case b := <-h.messages:
+		if string(b) != "ping" {
+			t.Fatalf("read %q, want ping", b)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message after ResumeRead")
+	}
+}
+
+func TestReserveHighWaterMark(t *testing.T) {
+	ev, err := newEventLoop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ev.Wait()
+	defer ev.Stop()
+	c := &conn{evLoop: ev, queued: 1, highWater: 1000}
+
+	// only enough senders to reach the mark may reserve, however they interleave
+	for round := 0; round < 200; round++ {
+		atomic.StoreInt64(&c.queued, 1)
+		var wg sync.WaitGroup
+		ready := make(chan struct{})
+		for i := 0; i < 32; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-ready
+				_ = c.reserve(100)
+			}()
+		}
+		close(ready)
+		wg.Wait()
+		if queued := atomic.LoadInt64(&c.queued); queued > 1000 {
+			t.Fatalf("queued %d bytes past a high water mark of 1000", queued)
+		}
+	}
+}
+
+type timeoutHandler struct {
+	nopHandler
+	opened chan Connection
+	closed chan error
+	// send is written to each connection once it opens
+	send []byte
+}
+
+func newTimeoutHandler(send []byte) *timeoutHandler {
+	return &timeoutHandler{opened: make(chan Connection, 1), closed: make(chan error, 1), send: send}
+}
+
+func (h *timeoutHandler) OnOpen(c Connection) {
+	if len(h.send) > 0 {
+		_ = c.Send(h.send, ActionNone)
+	}
+	h.opened <- c
+}
+
+func (h *timeoutHandler) OnClose(c Connection) {
+	h.closed <- c.CloseReason()
+}
+
+// closeReason waits for the connection of h to close within d.
+func (h *timeoutHandler) closeReason(t *testing.T, d time.Duration) error {
+	t.Helper()
+	select {
+	case err := <-h.closed:
+		return err
+	case <-time.After(d):
+		t.Fatal("connection not closed")
+		return nil
+	}
+}
+
+// dialTimeoutServer starts a server for h on an ephemeral port and dials it.
+func dialTimeoutServer(t *testing.T, opts *Options, h *timeoutHandler) (Server, net.Conn, Connection) {
+	t.Helper()
+	srv := NewServer(opts.SetAddr("tcp://127.0.0.1:0").SetNumLoops(1).SetHandler(h))
+	go srv.Start()
+	nc, err := net.Dial("tcp", listenAddr(t, srv).String())
+	if err != nil {
+		srv.Shutdown(context.Background())
+		t.Fatal(err)
+	}
+	return srv, nc, <-h.opened
+}
+
+func TestIdleTimeout(t *testing.T) {
+	h := newTimeoutHandler(nil)
+	srv, nc, _ := dialTimeoutServer(t, NewOptions().SetIdleTimeout(100*time.Millisecond), h)
+	defer srv.Shutdown(context.Background())
+	defer nc.Close()
+
+	// reads keep the connection alive past the timeout
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if _, err := nc.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case err := <-h.closed:
+		t.Fatalf("closed with %v while active", err)
+	default:
+	}
+	if err := h.closeReason(t, time.Second); err != ErrIdleTimeout {
+		t.Fatalf("CloseReason() = %v, want ErrIdleTimeout", err)
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Fatalf("closed after %v", d)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	// data flowing out doesn't count as reading
+	h := newTimeoutHandler([]byte("hello"))
+	srv, nc, _ := dialTimeoutServer(t, NewOptions().SetReadTimeout(100*time.Millisecond), h)
+	defer srv.Shutdown(context.Background())
+	defer nc.Close()
+
+	if err := h.closeReason(t, time.Second); err != ErrReadTimeout {
+		t.Fatalf("CloseReason() = %v, want ErrReadTimeout", err)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	// the peer never reads, so the queue can't drain past the socket buffers
+	h := newTimeoutHandler(make([]byte, 64<<20))
+	srv, nc, _ := dialTimeoutServer(t, NewOptions().SetWriteTimeout(100*time.Millisecond), h)
+	defer srv.Shutdown(context.Background())
+	defer nc.Close()
+
+	if err := h.closeReason(t, 2*time.Second); err != ErrWriteTimeout {
+		t.Fatalf("CloseReason() = %v, want ErrWriteTimeout", err)
+	}
+}
+
+func TestWriteTimeoutWhileStreaming(t *testing.T) {
+	const size = 8 << 20
+	h := newTimeoutHandler(nil)
+	srv, nc, c := dialTimeoutServer(t, NewOptions().SetWriteTimeout(150*time.Millisecond), h)
+	defer srv.Shutdown(context.Background())
+	defer nc.Close()
+
+	// small socket buffers keep the writes going for as long as the peer reads
+	if err := syscall.SetsockoptInt(c.Fd(), syscall.SOL_SOCKET, syscall.SO_SNDBUF, 64<<10); err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.(*net.TCPConn).SetReadBuffer(64 << 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(make([]byte, size), ActionNone); err != nil {
+		t.Fatal(err)
+	}
+
+	// the queue outlasts the timeout several times over but keeps moving
+	buf := make([]byte, 64<<10)
+	start := time.Now()
+	for read := 0; read < size; {
+		time.Sleep(5 * time.Millisecond)
+		_ = nc.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := nc.Read(buf)
+		if err != nil {
+			t.Fatalf("read %d of %d bytes after %v: %v", read, size, time.Since(start), err)
+		}
+		read += n
+	}
+	select {
+	case err := <-h.closed:
+		t.Fatalf("closed with %v while streaming", err)
+	default:
+	}
+}
+
+func TestSetReadDeadline(t *testing.T) {
+	h := newTimeoutHandler(nil)
+	srv, nc, c := dialTimeoutServer(t, NewOptions(), h)
+	defer srv.Shutdown(context.Background())
+	defer nc.Close()
+
+	// a deadline holds however much is read before it
+	start := time.Now()
+	if err := c.SetReadDeadline(start.Add(150 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if _, err := nc.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.closeReason(t, time.Second); err != ErrReadTimeout {
+		t.Fatalf("CloseReason() = %v, want ErrReadTimeout", err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Fatalf("closed after %v", d)
+	}
+}
+
+func TestSetWriteDeadline(t *testing.T) {
+	h := newTimeoutHandler(nil)
+	srv, nc, c := dialTimeoutServer(t, NewOptions(), h)
+	defer srv.Shutdown(context.Background())
+	defer nc.Close()
+
+	// nothing queued, the deadline passes without closing
+	if err := c.SetWriteDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-h.closed:
+		t.Fatalf("closed with %v with nothing queued", err)
+	default:
+	}
+
+	if err := c.SetWriteDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(make([]byte, 64<<20), ActionNone); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.closeReason(t, 2*time.Second); err != ErrWriteTimeout {
+		t.Fatalf("CloseReason() = %v, want ErrWriteTimeout", err)
+	}
+}
+
+type fileHandler struct {
+	nopHandler
+	f              *os.File
+	offset, length int64
+}
+
+func (h *fileHandler) OnMessage(c Connection, data []byte) {
+	_ = c.Send([]byte("head:"), ActionNone)
+	_ = c.SendFile(h.f, h.offset, h.length)
+	_ = c.Send([]byte(":tail"), ActionNone)
+}
+
+func (h *fileHandler) OnOpen(c Connection) {
+	// a small send buffer leaves most sendfile calls partial
+	_ = syscall.SetsockoptInt(c.Fd(), syscall.SOL_SOCKET, syscall.SO_SNDBUF, 64<<10)
+}
+
+func testSendFile(t *testing.T) {
+	content := make([]byte, 9<<20)
+	for i := range content {
+		content[i] = byte(i) ^ byte(i>>8) ^ byte(i>>16)
+	}
+	f, err := ioutil.TempFile("", "evnio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		offset, length int64
+	}{
+		// more than maxSendfileSize, so the range is sent over several calls
+		{name: "whole file", length: 0},
+		{name: "range", offset: 1000, length: 5 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &fileHandler{f: f, offset: tt.offset, length: tt.length}
+			srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(1).SetHandler(h))
+			go srv.Start()
+			defer srv.Shutdown(context.Background())
+			nc, err := net.Dial("tcp", listenAddr(t, srv).String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer nc.Close()
+			_ = nc.SetDeadline(time.Now().Add(10 * time.Second))
+			if _, err := nc.Write([]byte("go")); err != nil {
+				t.Fatal(err)
+			}
+
+			end := int64(len(content))
+			if tt.length > 0 {
+				end = tt.offset + tt.length
+			}
+			want := append(append([]byte("head:"), content[tt.offset:end]...), ":tail"...)
+			got := make([]byte, len(want))
+			if _, err := io.ReadFull(nc, got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				i := 0
+				for got[i] == want[i] {
+					i++
+				}
+				t.Fatalf("stream differs from byte %d", i)
+			}
+		})
+	}
+}
+
+func TestSendFile(t *testing.T) {
+	testSendFile(t)
+}
+
+func TestSendFileFallback(t *testing.T) {
+	defer func(fn func(int, int, *int64, int) (int, error)) { sendfile = fn }(sendfile)
+	calls := int32(0)
+	sendfile = func(outfd, infd int, offset *int64, count int) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return 0, syscall.EINVAL
+	}
+	testSendFile(t)
+	if atomic.LoadInt32(&calls) == 0 {
+		t.Fatal("sendfile was not tried")
+	}
+}
+
+func TestUniqIDNotReusedWithFd(t *testing.T) {
+	h := &pauseHandler{opened: make(chan Connection, 1), messages: make(chan []byte, 1)}
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(1).SetHandler(h))
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+	addr := listenAddr(t, srv).String()
+
+	var prev Connection
+	for i := 0; i < 3; i++ {
+		nc, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := <-h.opened
+		if got, ok := srv.Conn(c.UniqID()); !ok || got != c {
+			t.Fatalf("Conn(%d) = %v, %v", c.UniqID(), got, ok)
+		}
+		if prev != nil {
+			// the fd of a closed connection is usually handed out again, its ID never is
+			if c.UniqID() <= prev.UniqID() {
+				t.Fatalf("ID %d on fd %d after ID %d on fd %d", c.UniqID(), c.Fd(), prev.UniqID(), prev.Fd())
+			}
+			if _, ok := srv.Conn(prev.UniqID()); ok {
+				t.Fatalf("closed connection %d still found", prev.UniqID())
+			}
+		}
+		nc.Close()
+		waitFor(t, "close", func() bool { return srv.Count() == 0 })
+		prev = c
+	}
+}
@@ -0,0 +1,57 @@
+package evnio
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	defaultReconnectMinDelay = 100 * time.Millisecond
+	defaultReconnectMaxDelay = 30 * time.Second
+)
+
+var (
+	ErrDialTimeout  = errors.New("evnio: dial timeout")
+	ErrDialerClosed = errors.New("evnio: Dialer closed")
+)
+
+type DialHandler func(c Connection, err error)
+
+// Dialer opens outbound connections driven by the same Protocol and ConnectionHandler as a Server.
+type Dialer interface {
+	// Dial blocks until the connection is open, after its TLS handshake and OnOpen, or has
+	// failed. It must not be called from an event loop goroutine.
+	Dial(addr string) (Connection, error)
+
+	// DialAsync connects in the background and calls fn on the loop that owns the connection
+	// once it is open, after its TLS handshake and OnOpen, or has failed.
+	DialAsync(addr string, fn DialHandler)
+
+	// Close stops redialing and closes every connection opened by the Dialer.
+	Close() error
+}
+
+type backoff struct {
+	min, max, next time.Duration
+}
+
+func newBackoff(min, max time.Duration) *backoff {
+	if min <= 0 {
+		min = defaultReconnectMinDelay
+	}
+	if max <= 0 {
+		max = defaultReconnectMaxDelay
+	}
+	if max < min {
+		max = min
+	}
+	return &backoff{min: min, max: max, next: min}
+}
+
+func (b *backoff) delay() time.Duration {
+	d := b.next
+	if b.next *= 2; b.next > b.max {
+		b.next = b.max
+	}
+	return d
+}
@@ -0,0 +1,68 @@
+// +build !linux,!darwin,!netbsd,!freebsd,!openbsd,!dragonfly
+
+package evnio
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+
+	"github.com/dreamans/evnio/util"
+)
+
+type dialer struct {
+	opts   *Options
+	conns  sync.Map
+	closed util.AtomicBool
+}
+
+func NewDialer(opt *Options) Dialer {
+	return &dialer{
+		opts: opt,
+	}
+}
+
+func (d *dialer) Dial(addr string) (Connection, error) {
+	if d.closed.IsSet() {
+		return nil, ErrDialerClosed
+	}
+	network, address := util.ParseListenerAddr(addr)
+	var rw net.Conn
+	var err error
+	if d.opts.TLSConfig != nil {
+		rw, err = tls.DialWithDialer(&net.Dialer{Timeout: d.opts.DialTimeout}, network, address, d.opts.TLSConfig)
+	} else {
+		rw, err = net.DialTimeout(network, address, d.opts.DialTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := newConnection(rw, d.opts)
+	c.closeHooks = append(c.closeHooks, func() {
+		d.conns.Delete(c.UniqID())
+	})
+	d.conns.Store(c.UniqID(), c)
+	return c, nil
+}
+
+func (d *dialer) DialAsync(addr string, fn DialHandler) {
+	go func() {
+		c, err := d.Dial(addr)
+		if fn != nil {
+			fn(c, err)
+		}
+	}()
+}
+
+func (d *dialer) Close() error {
+	if d.closed.IsSet() {
+		return ErrDialerClosed
+	}
+	d.closed.Set()
+
+	d.conns.Range(func(key, value interface{}) bool {
+		_ = value.(*conn).Close()
+		return true
+	})
+	return nil
+}
@@ -0,0 +1,377 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"crypto/tls"
+	"net"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dreamans/evnio/evlog"
+	"github.com/dreamans/evnio/poller"
+	"github.com/dreamans/evnio/util"
+)
+
+type dialer struct {
+	mu         sync.Mutex
+	opts       *Options
+	evLoops    []*EventLoop
+	ownLoops   bool
+	balancer   LoadBalancer
+	handshakes *handshakeLimit
+	conns      sync.Map
+	closed     util.AtomicBool
+
+	// connectors and timers are the connects and redials Close must cancel, guarded by mu.
+	connectors map[*connector]struct{}
+	timers     map[*Timer]struct{}
+}
+
+func NewDialer(opt *Options) Dialer {
+	d := &dialer{
+		opts:       opt,
+		balancer:   opt.LoadBalancer,
+		handshakes: newHandshakeLimit(opt.MaxTLSHandshakes),
+		connectors: make(map[*connector]struct{}),
+		timers:     make(map[*Timer]struct{}),
+	}
+	if d.balancer == nil {
+		d.balancer = NewRoundRobinBalancer()
+	}
+	return d
+}
+
+func (d *dialer) Dial(addr string) (Connection, error) {
+	type result struct {
+		c   Connection
+		err error
+	}
+	ch := make(chan result, 1)
+	d.DialAsync(addr, func(c Connection, err error) {
+		ch <- result{c, err}
+	})
+	r := <-ch
+	return r.c, r.err
+}
+
+func (d *dialer) DialAsync(addr string, fn DialHandler) {
+	if fn == nil {
+		fn = func(Connection, error) {}
+	}
+	loops, err := d.eventLoops()
+	if err != nil {
+		fn(nil, err)
+		return
+	}
+	go func() {
+		network, address := util.ParseListenerAddr(addr)
+		sa, raddr, err := util.ResolveSockAddr(network, address)
+		if err != nil {
+			loops[0].Trigger(func() {
+				fn(nil, err)
+			})
+			return
+		}
+		loop := selectEventLoop(d.balancer, loops, raddr)
+		loop.Trigger(func() {
+			d.connect(loop, addr, sa, raddr, fn)
+		})
+	}()
+}
+
+func (d *dialer) Close() error {
+	if d.closed.IsSet() {
+		return ErrDialerClosed
+	}
+	d.mu.Lock()
+	d.closed.Set()
+	connectors, timers := d.connectors, d.timers
+	d.connectors, d.timers = nil, nil
+	var loops []*EventLoop
+	if d.ownLoops {
+		loops = d.evLoops
+	}
+	d.mu.Unlock()
+
+	// the loops may be shared, so pending connects and redials are cancelled one by one
+	for ct := range connectors {
+		_ = ct.Close()
+	}
+	for t := range timers {
+		t.Stop()
+	}
+	d.conns.Range(func(key, value interface{}) bool {
+		_ = value.(*conn).Close()
+		return true
+	})
+
+	// not under mu, the loops may be waiting for it
+	for _, loop := range loops {
+		_ = loop.Stop()
+	}
+	return nil
+}
+
+func (d *dialer) eventLoops() ([]*EventLoop, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed.IsSet() {
+		return nil, ErrDialerClosed
+	}
+	if d.evLoops != nil {
+		return d.evLoops, nil
+	}
+	if len(d.opts.EventLoops) > 0 {
+		d.evLoops = d.opts.EventLoops
+		return d.evLoops, nil
+	}
+
+	numLoops := d.opts.NumLoops
+	if numLoops == 0 {
+		numLoops = runtime.NumCPU()
+	}
+	loops := make([]*EventLoop, numLoops)
+	for i := 0; i < numLoops; i++ {
+		loop, err := newEventLoop()
+		if err != nil {
+			for _, l := range loops[:i] {
+				_ = l.Stop()
+			}
+			return nil, err
+		}
+		loops[i] = loop
+	}
+	for _, loop := range loops {
+		go loop.Wait()
+	}
+	d.evLoops, d.ownLoops = loops, true
+
+	return d.evLoops, nil
+}
+
+// connect runs on the loop goroutine and starts a non-blocking connect(2).
+func (d *dialer) connect(loop *EventLoop, addr string, sa syscall.Sockaddr, raddr net.Addr, fn DialHandler) {
+	if d.closed.IsSet() {
+		fn(nil, ErrDialerClosed)
+		return
+	}
+	sotype := syscall.SOCK_STREAM
+	if ua, ok := raddr.(*net.UnixAddr); ok && ua.Net == "unixpacket" {
+		sotype = syscall.SOCK_SEQPACKET
+	}
+	fd, err := util.NewNonblockSocket(sa, sotype)
+	if err != nil {
+		fn(nil, err)
+		return
+	}
+	if err := syscall.Connect(fd, sa); err != nil && err != syscall.EINPROGRESS {
+		_ = syscall.Close(fd)
+		fn(nil, err)
+		return
+	}
+
+	ct := &connector{
+		dialer: d,
+		fd:     fd,
+		evLoop: loop,
+		addr:   addr,
+		raddr:  raddr,
+		fn:     fn,
+	}
+	if !d.track(ct) {
+		_ = syscall.Close(fd)
+		fn(nil, ErrDialerClosed)
+		return
+	}
+	if err := loop.AddFdHandler(fd, ct); err != nil {
+		d.untrack(ct)
+		_ = syscall.Close(fd)
+		fn(nil, err)
+		return
+	}
+	if err := loop.EnableReadWrite(fd); err != nil {
+		ct.finish(err)
+		return
+	}
+	if d.opts.DialTimeout > 0 {
+		ct.timer = loop.AfterFunc(d.opts.DialTimeout, func() {
+			ct.finish(ErrDialTimeout)
+		})
+	}
+}
+
+// track records a pending connect for Close, it reports false once the Dialer is closed.
+func (d *dialer) track(ct *connector) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed.IsSet() {
+		return false
+	}
+	d.connectors[ct] = struct{}{}
+	return true
+}
+
+func (d *dialer) untrack(ct *connector) {
+	d.mu.Lock()
+	delete(d.connectors, ct)
+	d.mu.Unlock()
+}
+
+// afterFunc schedules fn on loop like AfterFunc, the timer is stopped by Close.
+func (d *dialer) afterFunc(loop *EventLoop, delay time.Duration, fn func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed.IsSet() {
+		return
+	}
+	var t *Timer
+	t = loop.AfterFunc(delay, func() {
+		d.mu.Lock()
+		delete(d.timers, t)
+		d.mu.Unlock()
+		fn()
+	})
+	d.timers[t] = struct{}{}
+}
+
+// newConnection turns a connected socket into a conn, it returns an error when the fd
+// is still the connector's to close.
+func (d *dialer) newConnection(ct *connector) error {
+	// a connect that completes after Close is not opened
+	if d.closed.IsSet() {
+		return ErrDialerClosed
+	}
+	var laddr net.Addr
+	if sa, err := syscall.Getsockname(ct.fd); err == nil {
+		laddr = util.SockAddrToAddr(sa)
+	}
+	ct.evLoop.reserveConn()
+	c := newConnection(ct.fd, ct.evLoop, ct.raddr, laddr, d.opts)
+	if d.opts.Reconnect {
+		c.closeHooks = append(c.closeHooks, func() {
+			// a connection that never opened was reported to its DialHandler, which retries
+			if c.opened {
+				d.redial(c, ct.addr)
+			}
+		})
+	}
+	c.closeHooks = append(c.closeHooks, func() {
+		d.conns.Delete(c.UniqID())
+	})
+	d.conns.Store(c.UniqID(), c)
+	// fn learns the outcome from open, or from handleClose if it fails before
+	c.dialed = ct.fn
+
+	ct.evLoop.handlers.Store(ct.fd, c)
+	if err := ct.evLoop.EnableRead(ct.fd); err != nil {
+		c.handleClose(ct.fd, err)
+		return nil
+	}
+	// Close may have ranged over the connections before this one was stored
+	if d.closed.IsSet() {
+		c.handleClose(ct.fd, ErrDialerClosed)
+		return nil
+	}
+	if d.opts.TLSConfig != nil {
+		c.startTLS(d.tlsConfig(ct.addr), true, d.handshakes, d.opts.TLSHandshakeTimeout)
+	} else {
+		c.open()
+	}
+	return nil
+}
+
+// tlsConfig fills in ServerName from addr the way tls.Dial does.
+func (d *dialer) tlsConfig(addr string) *tls.Config {
+	config := d.opts.TLSConfig
+	if config.ServerName != "" {
+		return config
+	}
+	_, address := util.ParseListenerAddr(addr)
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return config
+	}
+	config = config.Clone()
+	config.ServerName = host
+	return config
+}
+
+func (d *dialer) redial(c *conn, addr string) {
+	switch c.closeReason {
+	case ErrConnectionClosed, ErrServerClosed, ErrDialerClosed:
+		return
+	}
+	bo := newBackoff(d.opts.ReconnectMinDelay, d.opts.ReconnectMaxDelay)
+
+	var retry func()
+	retry = func() {
+		if d.closed.IsSet() {
+			return
+		}
+		d.DialAsync(addr, func(_ Connection, err error) {
+			if err == nil || err == ErrDialerClosed {
+				return
+			}
+			delay := bo.delay()
+			evlog.Errorf("[Dialer.redial]: %s, retry in %s", err.Error(), delay)
+			d.afterFunc(c.evLoop, delay, retry)
+		})
+	}
+	d.afterFunc(c.evLoop, bo.delay(), retry)
+}
+
+// connector watches a connecting socket until it turns writable.
+type connector struct {
+	dialer *dialer
+	fd     int
+	evLoop *EventLoop
+	addr   string
+	raddr  net.Addr
+	timer  *Timer
+	fn     DialHandler
+	done   bool
+}
+
+func (ct *connector) EventHandler(fd int, events poller.Event) {
+	if events&(poller.EventWrite|poller.EventErr) == 0 {
+		return
+	}
+	errno, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if err == nil && errno != 0 {
+		err = syscall.Errno(errno)
+	}
+	ct.finish(err)
+}
+
+func (ct *connector) Close() error {
+	ct.evLoop.Trigger(func() {
+		ct.finish(ErrDialerClosed)
+	})
+	return nil
+}
+
+func (ct *connector) finish(err error) {
+	if ct.done {
+		return
+	}
+	ct.done = true
+	if ct.timer != nil {
+		ct.timer.Stop()
+	}
+	ct.dialer.untrack(ct)
+
+	if err == nil {
+		err = ct.dialer.newConnection(ct)
+	}
+	if err != nil {
+		if e := ct.evLoop.DelFdHandler(ct.fd); e != nil {
+			evlog.Errorf("[evLoop.DelFdHandler]: %s", e.Error())
+		}
+		_ = syscall.Close(ct.fd)
+		ct.fn(nil, err)
+	}
+}
@@ -0,0 +1,209 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// blackhole returns the address of a listener whose backlog is full, connects to it
+// neither complete nor fail.
+func blackhole(t *testing.T) (addr string, cleanup func()) {
+	t.Helper()
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr = (&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: sa.(*syscall.SockaddrInet4).Port}).String()
+	// the one connection the backlog holds, never accepted
+	filler, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nc, err := net.DialTimeout("tcp", addr, 50*time.Millisecond); err == nil {
+		nc.Close()
+		filler.Close()
+		syscall.Close(fd)
+		t.Skip("connects to a full backlog complete on this system")
+	}
+	return addr, func() {
+		filler.Close()
+		syscall.Close(fd)
+	}
+}
+
+type dialHandler struct {
+	nopHandler
+	opened   int32
+	messages chan []byte
+}
+
+func (h *dialHandler) OnOpen(c Connection) {
+	atomic.AddInt32(&h.opened, 1)
+}
+
+func (h *dialHandler) OnMessage(c Connection, data []byte) {
+	h.messages <- append([]byte(nil), data...)
+}
+
+func TestDial(t *testing.T) {
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(1).SetHandler(echoHandler{}))
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+	addr := listenAddr(t, srv).String()
+
+	h := &dialHandler{messages: make(chan []byte, 1)}
+	d := NewDialer(NewOptions().SetNumLoops(1).SetHandler(h))
+	defer d.Close()
+	c, err := d.Dial("tcp://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&h.opened) != 1 {
+		t.Fatal("Dial returned before OnOpen")
+	}
+	if c.RemoteAddr().String() != addr {
+		t.Fatalf("RemoteAddr() = %s, want %s", c.RemoteAddr(), addr)
+	}
+	if err := c.Send([]byte("ping"), ActionNone); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case b := <-h.messages:
+		if string(b) != "ping" {
+			t.Fatalf("echo = %q", b)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no echo")
+	}
+
+	if _, err := d.Dial("tcp://127.0.0.1:1"); err != syscall.ECONNREFUSED {
+		t.Fatalf("Dial to a closed port = %v, want ECONNREFUSED", err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	addr, cleanup := blackhole(t)
+	defer cleanup()
+
+	d := NewDialer(NewOptions().SetNumLoops(1).SetHandler(nopHandler{}).SetDialTimeout(100 * time.Millisecond))
+	defer d.Close()
+	start := time.Now()
+	if _, err := d.Dial("tcp://" + addr); err != ErrDialTimeout {
+		t.Fatalf("Dial = %v, want ErrDialTimeout", err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond || d > time.Second {
+		t.Fatalf("timed out after %v", d)
+	}
+}
+
+func TestDialerCloseDuringConnect(t *testing.T) {
+	addr, cleanup := blackhole(t)
+	defer cleanup()
+
+	// with loops shared with a server, Close can't rely on stopping them
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(1).SetHandler(nopHandler{}))
+	defer srv.Shutdown(context.Background())
+	h := &dialHandler{}
+	d := NewDialer(NewOptions().SetEventLoops(srv.EventLoops()).SetHandler(h))
+
+	done := make(chan error, 1)
+	d.DialAsync("tcp://"+addr, func(c Connection, err error) {
+		done <- err
+	})
+	time.Sleep(50 * time.Millisecond)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != ErrDialerClosed {
+			t.Fatalf("pending dial = %v, want ErrDialerClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending dial not cancelled by Close")
+	}
+	if atomic.LoadInt32(&h.opened) != 0 {
+		t.Fatal("OnOpen called on a closed Dialer")
+	}
+	if _, err := d.Dial("tcp://" + addr); err != ErrDialerClosed {
+		t.Fatalf("Dial after Close = %v, want ErrDialerClosed", err)
+	}
+}
+
+func TestDialerReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	accepted := make(chan net.Conn, 8)
+	serve := func(ln net.Listener) {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- nc
+		}
+	}
+	go serve(ln)
+
+	h := &dialHandler{}
+	d := NewDialer(NewOptions().
+		SetNumLoops(1).
+		SetHandler(h).
+		SetReconnect(true).
+		SetReconnectDelay(20*time.Millisecond, 80*time.Millisecond))
+	defer d.Close()
+	if _, err := d.Dial("tcp://" + addr); err != nil {
+		t.Fatal(err)
+	}
+	first := <-accepted
+
+	// the server drops the connection and is down for a while, the redials back off
+	ln.Close()
+	first.Close()
+	time.Sleep(300 * time.Millisecond)
+	if n := atomic.LoadInt32(&h.opened); n != 1 {
+		t.Fatalf("opened %d times while the server was down", n)
+	}
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("can't listen on %s again: %v", addr, err)
+	}
+	defer ln.Close()
+	go serve(ln)
+
+	select {
+	case nc := <-accepted:
+		defer nc.Close()
+	case <-time.After(time.Second):
+		t.Fatal("no reconnect once the server was back")
+	}
+	waitFor(t, "reopen", func() bool { return atomic.LoadInt32(&h.opened) == 2 })
+}
+
+func TestBackoff(t *testing.T) {
+	bo := newBackoff(10*time.Millisecond, 50*time.Millisecond)
+	for i, want := range []time.Duration{10, 20, 40, 50, 50} {
+		if d := bo.delay(); d != want*time.Millisecond {
+			t.Fatalf("delay %d = %v, want %v", i, d, want*time.Millisecond)
+		}
+	}
+}
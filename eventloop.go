@@ -2,16 +2,20 @@ package evnio
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dreamans/evnio/poller"
 )
 
 type EventLoop struct {
+	numConns int64
 	mu       sync.Mutex
 	poll     poller.Poller
 	handlers sync.Map
 	packet   []byte
 	triggers []func()
+	timers   *timerWheel
 }
 
 type EventHandler interface {
@@ -22,11 +26,13 @@ type EventHandler interface {
 func newEventLoop() (*EventLoop, error) {
 	evLoop := &EventLoop{
 		packet: make([]byte, 0xFFFF),
+		timers: newTimerWheel(),
 	}
 	poll, err := poller.New(evLoop.eventHandler)
 	if err != nil {
 		return nil, err
 	}
+	poll.SetTimeoutHandler(evLoop.timers.timeout)
 	evLoop.poll = poll
 
 	return evLoop, nil
@@ -40,6 +46,42 @@ func (ev *EventLoop) Trigger(fn func()) {
 	_ = ev.poll.Trigger()
 }
 
+// AfterFunc calls fn on the loop goroutine once d has elapsed.
+func (ev *EventLoop) AfterFunc(d time.Duration, fn func()) *Timer {
+	return ev.addTimer(&Timer{fn: fn}, d)
+}
+
+// Every calls fn on the loop goroutine each time d elapses until the timer is stopped,
+// a d below the wheel's 10ms tick fires every tick.
+func (ev *EventLoop) Every(d time.Duration, fn func()) *Timer {
+	if d < timerWheelTick {
+		d = timerWheelTick
+	}
+	return ev.addTimer(&Timer{fn: fn, interval: d}, d)
+}
+
+func (ev *EventLoop) addTimer(t *Timer, d time.Duration) *Timer {
+	when := time.Now().Add(d)
+	ev.Trigger(func() {
+		ev.timers.add(t, when)
+	})
+	return t
+}
+
+func (ev *EventLoop) NumConnections() int {
+	return int(atomic.LoadInt64(&ev.numConns))
+}
+
+// reserveConn counts a connection on the loop before it is set up, so balancers
+// see it as soon as the loop is picked.
+func (ev *EventLoop) reserveConn() {
+	atomic.AddInt64(&ev.numConns, 1)
+}
+
+func (ev *EventLoop) releaseConn() {
+	atomic.AddInt64(&ev.numConns, -1)
+}
+
 func (ev *EventLoop) PacketBuf() []byte {
 	return ev.packet
 }
@@ -68,6 +110,14 @@ func (ev *EventLoop) EnableRead(fd int) error {
 	return ev.poll.EnableRead(fd)
 }
 
+func (ev *EventLoop) EnableWrite(fd int) error {
+	return ev.poll.EnableWrite(fd)
+}
+
+func (ev *EventLoop) Disable(fd int) error {
+	return ev.poll.Disable(fd)
+}
+
 func (ev *EventLoop) Wait() {
 	ev.poll.Wait()
 }
@@ -91,6 +141,10 @@ func (ev *EventLoop) eventHandler(fd int, events poller.Event) {
 	}
 
 	ev.doTriggers()
+
+	if fd < 0 {
+		ev.timers.advance(time.Now())
+	}
 }
 
 func (ev *EventLoop) doTriggers() {
@@ -1,12 +1,43 @@
 package evnio
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
+	"net"
+	"os"
+	"time"
 )
 
 type Server interface {
 	Start() error
-	Shutdown() error
+
+	// Shutdown stops accepting, lets connections flush their queued writes and closes them,
+	// connections still open when ctx is done are closed forcibly and ctx.Err() is returned.
+	Shutdown(ctx context.Context) error
+
+	// Stats returns the server counters.
+	Stats() Stats
+
+	// EventLoops returns the worker loops, starting them on first use so they can be shared with a Dialer before Start.
+	EventLoops() []*EventLoop
+
+	// Conn returns the open connection with the given UniqID. A connection is open from OnOpen
+	// to OnClose, not while its TLS handshake or PROXY header is pending.
+	Conn(id uint64) (Connection, bool)
+
+	// Range calls fn for each open connection until fn returns false.
+	Range(fn func(c Connection) bool)
+
+	// Count returns the number of open connections.
+	Count() int
+
+	// Broadcast sends data to every open connection accepted by filter, a nil filter accepts all.
+	// The writes run on the loop owning each connection, filter is called there too.
+	Broadcast(data []byte, filter func(c Connection) bool)
+
+	// Group returns the group called name, it exists from the first Add until its last member leaves.
+	Group(name string) Group
 }
 
 type Action uint8
@@ -16,13 +47,99 @@ const (
 	ActionClose
 )
 
-var ErrServerClosed = errors.New("evnio: Server closed")
+var (
+	ErrServerClosed = errors.New("evnio: Server closed")
+	ErrNotSupported = errors.New("evnio: not supported on this platform")
+)
 
 type Options struct {
 	Addr     string
 	NumLoops int
 	Protocol Protocol
 	Handler  ConnectionHandler
+
+	// Codec frames connections instead of Protocol when set, a Protocol also
+	// implementing Codec is used as one.
+	Codec Codec
+
+	// PacketHandler serves datagrams when Addr uses the udp:// scheme.
+	PacketHandler PacketHandler
+
+	// LoadBalancer assigns accepted and dialed connections to loops, round-robin when nil.
+	LoadBalancer LoadBalancer
+
+	// AcceptBatch caps the connections accepted per readiness event, 64 when zero.
+	AcceptBatch int
+
+	// ReusePort gives every worker loop its own SO_REUSEPORT socket so the kernel spreads
+	// connections and datagrams across loops instead of a single acceptor.
+	ReusePort bool
+
+	// UnixSocketMode is applied to the socket file of a unix:// listener between bind and listen,
+	// so the umask's permissions never accept a connection. Abstract addresses are left alone.
+	UnixSocketMode os.FileMode
+
+	// MaxConnections caps the connections a server serves at once, zero is unlimited.
+	MaxConnections int
+	// MaxConnectionsPerIP caps the connections served at once for a single remote IP, zero is unlimited.
+	MaxConnectionsPerIP int
+	// AllowNetworks, when not empty, is the set of remote networks allowed to connect.
+	AllowNetworks []*net.IPNet
+	// DenyNetworks are remote networks whose connections are closed, it takes precedence over AllowNetworks.
+	DenyNetworks []*net.IPNet
+
+	// IdleTimeout closes a connection that has neither read nor written for the duration.
+	IdleTimeout time.Duration
+	// ReadTimeout closes a connection when the peer sends nothing for the duration.
+	ReadTimeout time.Duration
+	// WriteTimeout closes a connection that has data queued but writes none of it for the duration.
+	WriteTimeout time.Duration
+
+	// TLSConfig makes accepted connections, or dialed ones on a Dialer, speak TLS,
+	// OnOpen is called once the handshake completes.
+	TLSConfig *tls.Config
+	// TLSHandshakeTimeout closes a connection whose handshake is not done within the duration
+	// of starting, the time spent waiting for MaxTLSHandshakes is not counted.
+	TLSHandshakeTimeout time.Duration
+	// MaxTLSHandshakes caps the handshakes in progress at once, connections past it wait for
+	// one to finish. Handshakes run on the loops, each keeps its suspended stack between the
+	// peer's flights, zero means 1024.
+	MaxTLSHandshakes int
+
+	// ProxyProtocol makes accepted connections read a HAProxy PROXY v1 or v2 header before
+	// anything else, RemoteAddr and LocalAddr then report the TCP addresses it carries.
+	ProxyProtocol ProxyProtocolMode
+	// ProxyHeaderTimeout closes a connection whose header has not arrived within the duration,
+	// zero means 5 seconds.
+	ProxyHeaderTimeout time.Duration
+	// ProxyTrustedNetworks lists the peers accepted in ProxyProtocol mode, others are rejected
+	// by admission control. A header sets the address admission control checks so empty trusts
+	// no TCP peer. Unix socket peers are local processes and always trusted.
+	ProxyTrustedNetworks []*net.IPNet
+
+	// ReadLimit and MessageLimit throttle each connection's bytes read and OnMessage calls
+	// per second, reading pauses until tokens refill instead of buffering.
+	ReadLimit    RateLimit
+	MessageLimit RateLimit
+	// ServerReadLimit and ServerMessageLimit are shared by all connections of a server.
+	ServerReadLimit    RateLimit
+	ServerMessageLimit RateLimit
+
+	// HighWaterMark makes Send return ErrWouldBlock once that many bytes are queued, zero is unbounded.
+	// Files queued by SendFile are not counted.
+	HighWaterMark int
+	// LowWaterMark is the queued size at which OnWritable fires after Send was refused, HighWaterMark/2 when zero.
+	LowWaterMark int
+
+	// DialTimeout bounds how long a Dialer waits for a connect to complete.
+	DialTimeout time.Duration
+	// Reconnect makes a Dialer redial connections lost for reasons other than a local Close.
+	Reconnect bool
+	// ReconnectMinDelay and ReconnectMaxDelay bound the exponential backoff between redials.
+	ReconnectMinDelay time.Duration
+	ReconnectMaxDelay time.Duration
+	// EventLoops are running loops a Dialer places its connections on instead of starting its own.
+	EventLoops []*EventLoop
 }
 
 func NewOptions() *Options {
@@ -44,7 +161,145 @@ func (opts *Options) SetProtocol(protocol Protocol) *Options {
 	return opts
 }
 
+func (opts *Options) SetCodec(codec Codec) *Options {
+	opts.Codec = codec
+	return opts
+}
+
 func (opts *Options) SetHandler(handler ConnectionHandler) *Options {
 	opts.Handler = handler
 	return opts
 }
+
+func (opts *Options) SetPacketHandler(handler PacketHandler) *Options {
+	opts.PacketHandler = handler
+	return opts
+}
+
+func (opts *Options) SetLoadBalancer(lb LoadBalancer) *Options {
+	opts.LoadBalancer = lb
+	return opts
+}
+
+func (opts *Options) SetAcceptBatch(n int) *Options {
+	opts.AcceptBatch = n
+	return opts
+}
+
+func (opts *Options) SetReusePort(reusePort bool) *Options {
+	opts.ReusePort = reusePort
+	return opts
+}
+
+func (opts *Options) SetUnixSocketMode(mode os.FileMode) *Options {
+	opts.UnixSocketMode = mode
+	return opts
+}
+
+func (opts *Options) SetIdleTimeout(d time.Duration) *Options {
+	opts.IdleTimeout = d
+	return opts
+}
+
+func (opts *Options) SetReadTimeout(d time.Duration) *Options {
+	opts.ReadTimeout = d
+	return opts
+}
+
+func (opts *Options) SetWriteTimeout(d time.Duration) *Options {
+	opts.WriteTimeout = d
+	return opts
+}
+
+func (opts *Options) SetMaxConnections(n int) *Options {
+	opts.MaxConnections = n
+	return opts
+}
+
+func (opts *Options) SetMaxConnectionsPerIP(n int) *Options {
+	opts.MaxConnectionsPerIP = n
+	return opts
+}
+
+func (opts *Options) SetAllowNetworks(networks []*net.IPNet) *Options {
+	opts.AllowNetworks = networks
+	return opts
+}
+
+func (opts *Options) SetDenyNetworks(networks []*net.IPNet) *Options {
+	opts.DenyNetworks = networks
+	return opts
+}
+
+func (opts *Options) SetTLSConfig(config *tls.Config) *Options {
+	opts.TLSConfig = config
+	return opts
+}
+
+func (opts *Options) SetTLSHandshakeTimeout(d time.Duration) *Options {
+	opts.TLSHandshakeTimeout = d
+	return opts
+}
+
+func (opts *Options) SetMaxTLSHandshakes(n int) *Options {
+	opts.MaxTLSHandshakes = n
+	return opts
+}
+
+func (opts *Options) SetProxyProtocol(mode ProxyProtocolMode, trusted []*net.IPNet) *Options {
+	opts.ProxyProtocol = mode
+	opts.ProxyTrustedNetworks = trusted
+	return opts
+}
+
+func (opts *Options) SetProxyHeaderTimeout(d time.Duration) *Options {
+	opts.ProxyHeaderTimeout = d
+	return opts
+}
+
+func (opts *Options) SetReadLimit(limit RateLimit) *Options {
+	opts.ReadLimit = limit
+	return opts
+}
+
+func (opts *Options) SetMessageLimit(limit RateLimit) *Options {
+	opts.MessageLimit = limit
+	return opts
+}
+
+func (opts *Options) SetServerReadLimit(limit RateLimit) *Options {
+	opts.ServerReadLimit = limit
+	return opts
+}
+
+func (opts *Options) SetServerMessageLimit(limit RateLimit) *Options {
+	opts.ServerMessageLimit = limit
+	return opts
+}
+
+func (opts *Options) SetWaterMarks(low, high int) *Options {
+	opts.LowWaterMark = low
+	opts.HighWaterMark = high
+	return opts
+}
+
+func (opts *Options) SetDialTimeout(d time.Duration) *Options {
+	opts.DialTimeout = d
+	return opts
+}
+
+func (opts *Options) SetReconnect(reconnect bool) *Options {
+	opts.Reconnect = reconnect
+	return opts
+}
+
+func (opts *Options) SetReconnectDelay(min, max time.Duration) *Options {
+	opts.ReconnectMinDelay = min
+	opts.ReconnectMaxDelay = max
+	return opts
+}
+
+func (opts *Options) SetEventLoops(loops []*EventLoop) *Options {
+	opts.EventLoops = loops
+	return opts
+}
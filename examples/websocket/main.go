@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 
 	"github.com/dreamans/evnio"
@@ -40,7 +41,7 @@ func main() {
 		Protocol: &websocket.Protocol{},
 	})
 
-	defer evSrv.Shutdown()
+	defer evSrv.Shutdown(context.Background())
 
 	err := evSrv.Start()
 	if err != nil {
@@ -1,8 +1,9 @@
 module github.com/dreamans/evnio
 
-go 1.13
+go 1.23
 
 require (
 	github.com/gorilla/websocket v1.4.1
 	github.com/sirupsen/logrus v1.4.2
+	golang.org/x/sys v0.0.0-20190422165155-953cdadca894
 )
@@ -0,0 +1,143 @@
+package evnio
+
+import "sync"
+
+// Group is a named set of connections sharing sends, members leave it when they are closed.
+type Group interface {
+	Name() string
+
+	// Add makes c a member, it returns false when c is closed or not an evnio connection.
+	Add(c Connection) bool
+
+	Remove(c Connection)
+
+	// Send sends data to every member, the writes run on the loop owning each member.
+	Send(data []byte)
+
+	// Len returns the number of members.
+	Len() int
+}
+
+type groups struct {
+	mu sync.Mutex
+	m  map[string]*group
+}
+
+// get returns a handle on the group called name. The group itself only exists
+// while it has members, so names that are no longer used don't pile up.
+func (gs *groups) get(name string) Group {
+	return &groupRef{gs: gs, name: name}
+}
+
+// lookup returns the group called name, the caller holds gs.mu.
+func (gs *groups) lookup(name string, create bool) *group {
+	g, ok := gs.m[name]
+	if !ok && create {
+		if gs.m == nil {
+			gs.m = make(map[string]*group)
+		}
+		g = newGroup(gs, name)
+		gs.m[name] = g
+	}
+	return g
+}
+
+func (gs *groups) find(name string) *group {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return gs.lookup(name, false)
+}
+
+// drop deletes g once its last member has left.
+func (gs *groups) drop(g *group) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.m[g.name] == g && g.Len() == 0 {
+		delete(gs.m, g.name)
+	}
+}
+
+// memberships tracks the groups a connection belongs to so it can leave them on close.
+// Locks are taken in the order memberships, groups, group.
+type memberships struct {
+	mu     sync.Mutex
+	groups map[*group]struct{}
+	left   bool
+}
+
+type groupRef struct {
+	gs   *groups
+	name string
+}
+
+func (r *groupRef) Name() string {
+	return r.name
+}
+
+func (r *groupRef) Add(c Connection) bool {
+	cc, ok := c.(*conn)
+	if !ok {
+		return false
+	}
+	m := &cc.memberships
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.left || cc.closed.IsSet() {
+		return false
+	}
+
+	r.gs.mu.Lock()
+	defer r.gs.mu.Unlock()
+	g := r.gs.lookup(r.name, true)
+	if _, ok := m.groups[g]; ok {
+		return true
+	}
+	if m.groups == nil {
+		m.groups = make(map[*group]struct{})
+	}
+	m.groups[g] = struct{}{}
+	g.insert(cc)
+	return true
+}
+
+func (r *groupRef) Remove(c Connection) {
+	cc, ok := c.(*conn)
+	if !ok {
+		return
+	}
+	m := &cc.memberships
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g := r.gs.find(r.name)
+	if _, ok := m.groups[g]; ok {
+		delete(m.groups, g)
+		g.delete(cc)
+		g.gs.drop(g)
+	}
+}
+
+func (r *groupRef) Send(data []byte) {
+	if g := r.gs.find(r.name); g != nil {
+		g.Send(data)
+	}
+}
+
+func (r *groupRef) Len() int {
+	if g := r.gs.find(r.name); g != nil {
+		return g.Len()
+	}
+	return 0
+}
+
+// leaveGroups removes c from all its groups, it is called once c is closed.
+func (c *conn) leaveGroups() {
+	m := &c.memberships
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.left = true
+	for g := range m.groups {
+		g.delete(c)
+		g.gs.drop(g)
+	}
+	m.groups = nil
+}
@@ -0,0 +1,51 @@
+// +build !linux,!darwin,!netbsd,!freebsd,!openbsd,!dragonfly
+
+package evnio
+
+import "sync"
+
+type group struct {
+	gs      *groups
+	name    string
+	mu      sync.RWMutex
+	members map[uint64]*conn
+}
+
+func newGroup(gs *groups, name string) *group {
+	return &group{
+		gs:      gs,
+		name:    name,
+		members: make(map[uint64]*conn),
+	}
+}
+
+func (g *group) insert(c *conn) {
+	g.mu.Lock()
+	g.members[c.uniqID] = c
+	g.mu.Unlock()
+}
+
+func (g *group) delete(c *conn) {
+	g.mu.Lock()
+	delete(g.members, c.uniqID)
+	g.mu.Unlock()
+}
+
+func (g *group) Len() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return len(g.members)
+}
+
+func (g *group) Send(data []byte) {
+	g.mu.RLock()
+	conns := make([]*conn, 0, len(g.members))
+	for _, c := range g.members {
+		conns = append(conns, c)
+	}
+	g.mu.RUnlock()
+
+	for _, c := range conns {
+		_ = c.Send(data, ActionNone)
+	}
+}
@@ -0,0 +1,92 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"sync"
+
+	"github.com/dreamans/evnio/evlog"
+)
+
+// group keeps its members per loop so a send costs a single Trigger per loop.
+type group struct {
+	gs     *groups
+	name   string
+	mu     sync.RWMutex
+	shards map[*EventLoop]map[uint64]*conn
+	n      int
+}
+
+func newGroup(gs *groups, name string) *group {
+	return &group{
+		gs:     gs,
+		name:   name,
+		shards: make(map[*EventLoop]map[uint64]*conn),
+	}
+}
+
+func (g *group) insert(c *conn) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	shard, ok := g.shards[c.evLoop]
+	if !ok {
+		shard = make(map[uint64]*conn)
+		g.shards[c.evLoop] = shard
+	}
+	shard[c.uniqID] = c
+	g.n++
+}
+
+func (g *group) delete(c *conn) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	shard := g.shards[c.evLoop]
+	if _, ok := shard[c.uniqID]; !ok {
+		return
+	}
+	delete(shard, c.uniqID)
+	if len(shard) == 0 {
+		delete(g.shards, c.evLoop)
+	}
+	g.n--
+}
+
+func (g *group) Len() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.n
+}
+
+func (g *group) Send(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	// one copy is shared by every member's write queue
+	data = append([]byte(nil), data...)
+	g.mu.RLock()
+	loops := make([]*EventLoop, 0, len(g.shards))
+	for loop := range g.shards {
+		loops = append(loops, loop)
+	}
+	g.mu.RUnlock()
+
+	for _, loop := range loops {
+		loop := loop
+		loop.Trigger(func() {
+			// members may leave the group while sending, so send to a snapshot
+			g.mu.RLock()
+			shard := g.shards[loop]
+			conns := make([]*conn, 0, len(shard))
+			for _, c := range shard {
+				conns = append(conns, c)
+			}
+			g.mu.RUnlock()
+
+			for _, c := range conns {
+				if err := c.sendOnLoop(data, ActionNone); err != nil && err != ErrConnectionClosed {
+					evlog.Debugf("[Group.Send]: group %s, conn %d, %s", g.name, c.uniqID, err.Error())
+				}
+			}
+		})
+	}
+}
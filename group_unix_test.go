@@ -0,0 +1,132 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGroupDroppedWhenEmpty(t *testing.T) {
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(2).SetHandler(nopHandler{}))
+	gs := &srv.(*server).groups
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+	addr := listenAddr(t, srv).String()
+
+	numGroups := func() int {
+		gs.mu.Lock()
+		defer gs.mu.Unlock()
+		return len(gs.m)
+	}
+
+	var clients []net.Conn
+	defer func() {
+		for _, nc := range clients {
+			nc.Close()
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		nc, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, nc)
+	}
+	waitFor(t, "connections", func() bool { return srv.Count() == 2 })
+	var conns []Connection
+	srv.Range(func(c Connection) bool {
+		conns = append(conns, c)
+		return true
+	})
+
+	room := srv.Group("room")
+	if srv.Group("lobby").Len() != 0 || numGroups() != 0 {
+		t.Fatal("looking a group up created it")
+	}
+	for _, c := range conns {
+		if !room.Add(c) {
+			t.Fatal("Add failed")
+		}
+	}
+	if n := srv.Group("room").Len(); n != 2 || numGroups() != 1 {
+		t.Fatalf("room has %d members in %d groups", n, numGroups())
+	}
+
+	room.Remove(conns[0])
+	if room.Len() != 1 || numGroups() != 1 {
+		t.Fatal("group dropped while it still has a member")
+	}
+	// the last member leaving by closing drops the group
+	_ = conns[1].Close()
+	waitFor(t, "group drop", func() bool { return numGroups() == 0 })
+
+	// a handle outlives its group and recreates it
+	if !room.Add(conns[0]) {
+		t.Fatal("Add after drop failed")
+	}
+	if n := srv.Group("room").Len(); n != 1 {
+		t.Fatalf("room has %d members after re-adding, want 1", n)
+	}
+	room.Remove(conns[0])
+	if numGroups() != 0 {
+		t.Fatal("group kept after Remove emptied it")
+	}
+}
+
+func TestGroupSend(t *testing.T) {
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(2).SetHandler(nopHandler{}))
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+	clients := dialClients(t, srv, 4)
+
+	room := srv.Group("room")
+	var members []Connection
+	var outsider net.Conn
+	for id, nc := range clients {
+		if outsider == nil {
+			outsider = nc
+			continue
+		}
+		c, _ := srv.Conn(id)
+		if !room.Add(c) {
+			t.Fatal("Add failed")
+		}
+		members = append(members, c)
+	}
+
+	room.Send([]byte("hello"))
+	for _, c := range members {
+		expectRead(t, clients[c.UniqID()], []byte("hello"))
+	}
+	expectRead(t, outsider, nil)
+
+	// a member closed while sends are queued on its loop is dropped, the others get every send
+	const sends = 100
+	leaving, staying := members[0], members[1:]
+	for i := 0; i < sends; i++ {
+		if i == sends/2 {
+			_ = leaving.Close()
+		}
+		room.Send([]byte("x"))
+	}
+	waitFor(t, "member drop", func() bool { return room.Len() == len(staying) })
+	for _, c := range staying {
+		expectRead(t, clients[c.UniqID()], bytes.Repeat([]byte("x"), sends))
+	}
+	nc := clients[leaving.UniqID()]
+	_ = nc.SetReadDeadline(time.Now().Add(time.Second))
+	if b, err := ioutil.ReadAll(nc); err != nil || len(b) > sends/2 {
+		t.Fatalf("closed member read %d bytes, %v, want at most %d and EOF", len(b), err, sends/2)
+	}
+	expectRead(t, outsider, nil)
+
+	room.Send([]byte("bye"))
+	for _, c := range staying {
+		expectRead(t, clients[c.UniqID()], []byte("bye"))
+	}
+}
@@ -0,0 +1,181 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	nethttp "net/http"
+	"strconv"
+	"strings"
+)
+
+const maxChunkLineLength = 4096
+
+var (
+	crlf       = []byte("\r\n")
+	headerEnd  = []byte("\r\n\r\n")
+	errChunked = errors.New("http: malformed chunked encoding")
+)
+
+// statusError is a malformed request answered with Code before the connection is closed.
+type statusError struct {
+	Code int
+	Err  error
+}
+
+func (e *statusError) Error() string {
+	return e.Err.Error()
+}
+
+// parser reads one request at a time from the start of the connection's buffered input,
+// it keeps its progress between calls as the input only grows until the request is taken.
+type parser struct {
+	maxHeaderBytes int
+	maxBodyBytes   int
+
+	// scanned is how much of the input was searched for the end of the head.
+	scanned int
+	req     *nethttp.Request
+	headLen int
+	chunked bool
+	// next is the offset of the next chunk size line.
+	next int
+	body []byte
+}
+
+// parse returns the request at the start of data and its length, a nil request
+// with a nil error means more data is needed.
+func (p *parser) parse(data []byte) (*nethttp.Request, int, error) {
+	if p.req == nil {
+		if err := p.parseHead(data); err != nil || p.req == nil {
+			return nil, 0, err
+		}
+	}
+
+	var n int
+	if p.chunked {
+		end, err := p.parseChunks(data)
+		if err != nil || end == 0 {
+			return nil, 0, err
+		}
+		n = end
+	} else {
+		n = p.headLen + int(p.req.ContentLength)
+		if len(data) < n {
+			return nil, 0, nil
+		}
+		p.body = append(p.body, data[p.headLen:n]...)
+	}
+
+	req := p.req
+	if len(p.body) > 0 {
+		req.Body = &body{Reader: bytes.NewReader(p.body)}
+	} else {
+		req.Body = nethttp.NoBody
+	}
+	p.reset()
+	return req, n, nil
+}
+
+func (p *parser) reset() {
+	p.scanned = 0
+	p.req = nil
+	p.headLen = 0
+	p.chunked = false
+	p.next = 0
+	p.body = nil
+}
+
+func (p *parser) parseHead(data []byte) error {
+	from := p.scanned - len(headerEnd) + 1
+	if from < 0 {
+		from = 0
+	}
+	i := bytes.Index(data[from:], headerEnd)
+	if i == -1 {
+		if len(data) > p.maxHeaderBytes {
+			return &statusError{nethttp.StatusRequestHeaderFieldsTooLarge, errors.New("http: request header too large")}
+		}
+		p.scanned = len(data)
+		return nil
+	}
+	headLen := from + i + len(headerEnd)
+	if headLen > p.maxHeaderBytes {
+		return &statusError{nethttp.StatusRequestHeaderFieldsTooLarge, errors.New("http: request header too large")}
+	}
+
+	req, err := nethttp.ReadRequest(bufio.NewReaderSize(bytes.NewReader(data[:headLen]), headLen))
+	if err != nil {
+		return &statusError{nethttp.StatusBadRequest, err}
+	}
+	if len(req.TransferEncoding) > 0 && req.TransferEncoding[0] == "chunked" {
+		p.chunked = true
+		p.next = headLen
+	} else if req.ContentLength > int64(p.maxBodyBytes) {
+		return &statusError{nethttp.StatusRequestEntityTooLarge, errors.New("http: request body too large")}
+	} else if req.ContentLength < 0 {
+		req.ContentLength = 0
+	}
+	p.req = req
+	p.headLen = headLen
+	return nil
+}
+
+// parseChunks decodes the chunks available in data and returns the end of the
+// request once the last chunk and trailer have been read.
+func (p *parser) parseChunks(data []byte) (int, error) {
+	for {
+		i := bytes.Index(data[p.next:], crlf)
+		if i == -1 {
+			if len(data)-p.next > maxChunkLineLength {
+				return 0, &statusError{nethttp.StatusBadRequest, errChunked}
+			}
+			return 0, nil
+		}
+		line := string(data[p.next : p.next+i])
+		if j := strings.IndexByte(line, ';'); j >= 0 {
+			line = line[:j]
+		}
+		size, err := strconv.ParseUint(strings.TrimSpace(line), 16, 63)
+		if err != nil {
+			return 0, &statusError{nethttp.StatusBadRequest, errChunked}
+		}
+		start := p.next + i + len(crlf)
+
+		if size == 0 {
+			// trailers are read but not exposed
+			if bytes.HasPrefix(data[start:], crlf) {
+				return start + len(crlf), nil
+			}
+			end := bytes.Index(data[start:], headerEnd)
+			if end == -1 {
+				if len(data)-start > p.maxHeaderBytes {
+					return 0, &statusError{nethttp.StatusRequestHeaderFieldsTooLarge, errors.New("http: request trailer too large")}
+				}
+				return 0, nil
+			}
+			return start + end + len(headerEnd), nil
+		}
+
+		if uint64(len(p.body))+size > uint64(p.maxBodyBytes) {
+			return 0, &statusError{nethttp.StatusRequestEntityTooLarge, errors.New("http: request body too large")}
+		}
+		end := start + int(size)
+		if len(data) < end+len(crlf) {
+			return 0, nil
+		}
+		if !bytes.Equal(data[end:end+len(crlf)], crlf) {
+			return 0, &statusError{nethttp.StatusBadRequest, errChunked}
+		}
+		p.body = append(p.body, data[start:end]...)
+		p.next = end + len(crlf)
+	}
+}
+
+type body struct {
+	*bytes.Reader
+}
+
+func (b *body) Close() error {
+	return nil
+}
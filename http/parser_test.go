@@ -0,0 +1,118 @@
+package http
+
+import (
+	"io/ioutil"
+	nethttp "net/http"
+	"strings"
+	"testing"
+)
+
+func newTestParser() *parser {
+	return &parser{maxHeaderBytes: 256, maxBodyBytes: 16}
+}
+
+func TestParser(t *testing.T) {
+	longHeader := "GET / HTTP/1.1\r\nHost: a\r\nX-Long: " + strings.Repeat("x", 256) + "\r\n\r\n"
+
+	tests := []struct {
+		name string
+		in   string
+		// done is set when in is a whole request, more data is needed otherwise
+		done bool
+		body string
+		code int
+	}{
+		{name: "get", in: "GET / HTTP/1.1\r\nHost: a\r\n\r\n", done: true},
+		{name: "content length", in: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhello", done: true, body: "hello"},
+		{name: "chunked", in: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n3;ext=1\r\n!!!\r\n0\r\n\r\n",
+			done: true, body: "hello!!!"},
+		{name: "chunked trailer", in: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhi\r\n0\r\nX-Sum: 1\r\n\r\n",
+			done: true, body: "hi"},
+		{name: "head pending", in: "GET / HTTP/1.1\r\nHost: a\r\n"},
+		{name: "body pending", in: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhel"},
+		{name: "chunk pending", in: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel"},
+		{name: "trailer pending", in: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nX-Sum: 1\r\n"},
+		{name: "header too large", in: longHeader, code: nethttp.StatusRequestHeaderFieldsTooLarge},
+		{name: "header too large without end", in: longHeader[:260], code: nethttp.StatusRequestHeaderFieldsTooLarge},
+		{name: "body too large", in: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 17\r\n\r\n", code: nethttp.StatusRequestEntityTooLarge},
+		{name: "chunked body too large", in: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n10\r\n" + strings.Repeat("x", 16) + "\r\n1\r\nx\r\n0\r\n\r\n",
+			code: nethttp.StatusRequestEntityTooLarge},
+		{name: "bad request line", in: "GET /\r\nHost: a\r\n\r\n", code: nethttp.StatusBadRequest},
+		{name: "bad chunk size", in: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", code: nethttp.StatusBadRequest},
+		{name: "chunk without crlf", in: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhiX\r\n", code: nethttp.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, n, err := newTestParser().parse([]byte(tt.in))
+			if tt.code != 0 {
+				se, ok := err.(*statusError)
+				if !ok || se.Code != tt.code {
+					t.Fatalf("err = %v, want status %d", err, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.done && n != len(tt.in) || !tt.done && n != 0 {
+				t.Fatalf("n = %d of %d bytes, done %v", n, len(tt.in), tt.done)
+			}
+			if !tt.done {
+				if req != nil {
+					t.Fatal("request returned before it was complete")
+				}
+				return
+			}
+			body, _ := ioutil.ReadAll(req.Body)
+			if string(body) != tt.body {
+				t.Fatalf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestParserSplitReads(t *testing.T) {
+	for _, in := range []string{
+		"POST /a HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhello",
+		"POST /a HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhe\r\n3\r\nllo\r\n0\r\nX-Sum: 1\r\n\r\n",
+	} {
+		p := newTestParser()
+		for i := 1; i < len(in); i++ {
+			if req, n, err := p.parse([]byte(in[:i])); req != nil || n != 0 || err != nil {
+				t.Fatalf("%q: prefix of %d bytes = %v, %d, %v", in, i, req, n, err)
+			}
+		}
+		req, n, err := p.parse([]byte(in))
+		if err != nil || req == nil || n != len(in) {
+			t.Fatalf("%q: = %v, %d, %v", in, req, n, err)
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		if req.URL.Path != "/a" || string(body) != "hello" {
+			t.Fatalf("%q: path %q, body %q", in, req.URL.Path, body)
+		}
+	}
+}
+
+func TestParserPipelining(t *testing.T) {
+	in := []byte("GET /1 HTTP/1.1\r\nHost: a\r\n\r\n" +
+		"POST /2 HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n1\r\nx\r\n0\r\n\r\n" +
+		"POST /3 HTTP/1.1\r\nHost: a\r\nContent-Length: 1\r\n\r\ny" +
+		"GET /4 HTTP/1.1\r\n")
+
+	p := newTestParser()
+	var paths []string
+	for {
+		req, n, err := p.parse(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req == nil {
+			break
+		}
+		paths = append(paths, req.URL.Path)
+		in = in[n:]
+	}
+	if strings.Join(paths, " ") != "/1 /2 /3" || string(in) != "GET /4 HTTP/1.1\r\n" {
+		t.Fatalf("paths %v, rest %q", paths, in)
+	}
+}
@@ -0,0 +1,97 @@
+package http
+
+import (
+	"bytes"
+	nethttp "net/http"
+	"strconv"
+	"time"
+)
+
+// response buffers what the handler writes, it is serialized once ServeHTTP returns.
+type response struct {
+	req         *nethttp.Request
+	header      nethttp.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newResponse(req *nethttp.Request) *response {
+	return &response{
+		req:    req,
+		header: make(nethttp.Header),
+		status: nethttp.StatusOK,
+	}
+}
+
+func (w *response) Header() nethttp.Header {
+	return w.header
+}
+
+func (w *response) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+}
+
+func (w *response) Write(b []byte) (int, error) {
+	w.WriteHeader(nethttp.StatusOK)
+	if !bodyAllowed(w.status) {
+		return 0, nethttp.ErrBodyNotAllowed
+	}
+	return w.body.Write(b)
+}
+
+func (w *response) WriteString(s string) (int, error) {
+	w.WriteHeader(nethttp.StatusOK)
+	if !bodyAllowed(w.status) {
+		return 0, nethttp.ErrBodyNotAllowed
+	}
+	return w.body.WriteString(s)
+}
+
+// closeAfter reports whether the connection must be closed once the response is sent.
+func (w *response) closeAfter() bool {
+	return w.req.Close || hasToken(w.header.Get("Connection"), "close")
+}
+
+// bytes serializes the response, HTTP/1.0 clients asking for keep-alive get it confirmed.
+func (w *response) bytes() []byte {
+	h := w.header
+	if bodyAllowed(w.status) {
+		if h.Get("Content-Length") == "" {
+			h.Set("Content-Length", strconv.Itoa(w.body.Len()))
+		}
+		if h.Get("Content-Type") == "" && w.body.Len() > 0 {
+			h.Set("Content-Type", nethttp.DetectContentType(w.body.Bytes()))
+		}
+	}
+	if h.Get("Date") == "" {
+		h.Set("Date", time.Now().UTC().Format(nethttp.TimeFormat))
+	}
+	if w.closeAfter() {
+		h.Set("Connection", "close")
+	} else if !w.req.ProtoAtLeast(1, 1) {
+		h.Set("Connection", "keep-alive")
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(128 + w.body.Len())
+	buf.WriteString("HTTP/1.1 ")
+	buf.WriteString(strconv.Itoa(w.status))
+	buf.WriteByte(' ')
+	buf.WriteString(nethttp.StatusText(w.status))
+	buf.WriteString("\r\n")
+	_ = h.Write(&buf)
+	buf.WriteString("\r\n")
+	if w.req.Method != nethttp.MethodHead {
+		buf.Write(w.body.Bytes())
+	}
+	return buf.Bytes()
+}
+
+func bodyAllowed(status int) bool {
+	return (status < 100 || status > 199) && status != nethttp.StatusNoContent && status != nethttp.StatusNotModified
+}
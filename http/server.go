@@ -0,0 +1,236 @@
+// Package http serves HTTP/1.1 on evnio connections, requests are handled on the
+// connection's loop goroutine by a net/http Handler.
+package http
+
+import (
+	nethttp "net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dreamans/evnio"
+	"github.com/dreamans/evnio/evlog"
+)
+
+// DefaultMaxBodyBytes bounds request bodies when Server.MaxBodyBytes is zero.
+const DefaultMaxBodyBytes = 4 << 20
+
+var continueResponse = []byte("HTTP/1.1 100 Continue\r\n\r\n")
+
+// Server is both the Codec and the ConnectionHandler of the connections it serves:
+//
+//	srv := &http.Server{Handler: mux}
+//	evnio.NewServer(evnio.NewOptions().SetAddr(":8080").SetCodec(srv).SetHandler(srv))
+//
+// Requests and their bodies are read completely before Handler is called, responses
+// are buffered and sent once ServeHTTP returns. Pipelined requests are answered in order.
+type Server struct {
+	// Handler serves the requests, nethttp.DefaultServeMux when nil.
+	Handler nethttp.Handler
+	// MaxHeaderBytes bounds the request line and headers, nethttp.DefaultMaxHeaderBytes when zero.
+	MaxHeaderBytes int
+	// MaxBodyBytes bounds request bodies, DefaultMaxBodyBytes when zero.
+	MaxBodyBytes int
+	// Upgrades hands the connections asking to upgrade to the protocol named by a lower case
+	// key, such as "websocket", to its handler. The handler gets OnOpen, then OnMessage with the
+	// raw upgrade request and, unframed, every byte read afterwards, which is what a
+	// *websocket.Websocket expects from websocket.Protocol.
+	Upgrades map[string]evnio.ConnectionHandler
+
+	connections sync.Map
+}
+
+type connState struct {
+	parser   parser
+	ready    *nethttp.Request
+	closing  bool
+	upgraded evnio.ConnectionHandler
+}
+
+func (s *Server) OnOpen(c evnio.Connection) {
+	st := &connState{
+		parser: parser{
+			maxHeaderBytes: s.MaxHeaderBytes,
+			maxBodyBytes:   s.MaxBodyBytes,
+		},
+	}
+	if st.parser.maxHeaderBytes <= 0 {
+		st.parser.maxHeaderBytes = nethttp.DefaultMaxHeaderBytes
+	}
+	if st.parser.maxBodyBytes <= 0 {
+		st.parser.maxBodyBytes = DefaultMaxBodyBytes
+	}
+	s.connections.Store(c.UniqID(), st)
+}
+
+func (s *Server) Decode(c evnio.Connection, data []byte) ([]byte, int, error) {
+	if len(data) == 0 {
+		return nil, 0, nil
+	}
+	st, ok := s.state(c)
+	if !ok {
+		return nil, 0, nil
+	}
+	if st.upgraded != nil {
+		return data, len(data), nil
+	}
+	if st.closing {
+		// the response closes the connection, what the peer sends meanwhile is dropped
+		return nil, len(data), nil
+	}
+
+	wasHead := st.parser.req != nil
+	req, n, err := st.parser.parse(data)
+	if err != nil {
+		// OnError answers it once the connection stopped reading
+		return nil, 0, err
+	}
+	if req == nil {
+		if !wasHead && st.parser.req != nil && expectsContinue(st.parser.req) {
+			_ = c.Send(continueResponse, evnio.ActionNone)
+		}
+		return nil, 0, nil
+	}
+	st.ready = req
+	return data[:n], n, nil
+}
+
+func (s *Server) Encode(c evnio.Connection, data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (s *Server) OnMessage(c evnio.Connection, data []byte) {
+	st, ok := s.state(c)
+	if !ok {
+		_ = c.Close()
+		return
+	}
+	if st.upgraded != nil {
+		st.upgraded.OnMessage(c, data)
+		return
+	}
+	req := st.ready
+	st.ready = nil
+	if req == nil {
+		return
+	}
+
+	if h := s.upgrade(req); h != nil {
+		st.upgraded = h
+		h.OnOpen(c)
+		h.OnMessage(c, data)
+		return
+	}
+
+	req.RemoteAddr = c.RemoteAddr().String()
+	if state, ok := c.TLSConnectionState(); ok {
+		req.TLS = &state
+	}
+	w := newResponse(req)
+	if !s.serve(w, req.WithContext(c.Context())) {
+		s.closing(c, st)
+		s.sendError(c, &statusError{Code: nethttp.StatusInternalServerError})
+		return
+	}
+
+	action := evnio.ActionNone
+	if w.closeAfter() {
+		s.closing(c, st)
+		action = evnio.ActionClose
+	}
+	if err := c.Send(w.bytes(), action); err != nil {
+		evlog.Errorf("[http.Server.OnMessage]: %s", err.Error())
+		_ = c.Close()
+	}
+}
+
+func (s *Server) OnClose(c evnio.Connection) {
+	st, ok := s.state(c)
+	if !ok {
+		return
+	}
+	s.connections.Delete(c.UniqID())
+	if st.upgraded != nil {
+		st.upgraded.OnClose(c)
+	}
+}
+
+// OnError answers a malformed request with its status, such as 400 or 431, the
+// connection is closed once the response is written.
+func (s *Server) OnError(c evnio.Connection, err error) {
+	evlog.Debugf("[http.Server.OnError]: %s", err.Error())
+	se, ok := err.(*statusError)
+	if !ok {
+		se = &statusError{Code: nethttp.StatusBadRequest, Err: err}
+	}
+	s.sendError(c, se)
+}
+
+// closing stops reading a connection whose last response is being sent.
+func (s *Server) closing(c evnio.Connection, st *connState) {
+	st.closing = true
+	_ = c.PauseRead()
+}
+
+// serve calls the handler and reports whether it returned without panicking.
+func (s *Server) serve(w *response, req *nethttp.Request) (ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			evlog.Errorf("[http.Server.serve]: panic serving %s: %v", req.RemoteAddr, err)
+			ok = false
+		}
+	}()
+
+	handler := s.Handler
+	if handler == nil {
+		handler = nethttp.DefaultServeMux
+	}
+	handler.ServeHTTP(w, req)
+	return true
+}
+
+func (s *Server) sendError(c evnio.Connection, err *statusError) {
+	text := nethttp.StatusText(err.Code)
+	resp := "HTTP/1.1 " + strconv.Itoa(err.Code) + " " + text + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Length: " + strconv.Itoa(len(text)) + "\r\n" +
+		"Connection: close\r\n\r\n" + text
+	if c.Send([]byte(resp), evnio.ActionClose) != nil {
+		_ = c.Close()
+	}
+}
+
+// upgrade returns the handler taking over a connection asking to upgrade.
+func (s *Server) upgrade(req *nethttp.Request) evnio.ConnectionHandler {
+	if len(s.Upgrades) == 0 || !hasToken(req.Header.Get("Connection"), "upgrade") {
+		return nil
+	}
+	protocol := strings.ToLower(strings.TrimSpace(strings.SplitN(req.Header.Get("Upgrade"), ",", 2)[0]))
+	if i := strings.IndexByte(protocol, '/'); i >= 0 {
+		protocol = protocol[:i]
+	}
+	return s.Upgrades[protocol]
+}
+
+func (s *Server) state(c evnio.Connection) (*connState, bool) {
+	st, ok := s.connections.Load(c.UniqID())
+	if !ok {
+		return nil, false
+	}
+	return st.(*connState), true
+}
+
+func expectsContinue(req *nethttp.Request) bool {
+	return req.ProtoAtLeast(1, 1) && (req.ContentLength > 0 || len(req.TransferEncoding) > 0) &&
+		strings.EqualFold(strings.TrimSpace(req.Header.Get("Expect")), "100-continue")
+}
+
+// hasToken reports whether the comma separated header value v contains token.
+func hasToken(v, token string) bool {
+	for _, t := range strings.Split(v, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package http
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	nethttp "net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dreamans/evnio"
+	"github.com/dreamans/evnio/websocket"
+)
+
+// serve starts srv on a free port and returns a connected client.
+func serve(t *testing.T, srv *Server) (net.Conn, func()) {
+	t.Helper()
+	// evnio.Server doesn't report its listen address, so the port is picked here
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	es := evnio.NewServer(evnio.NewOptions().SetAddr("tcp://" + addr).SetNumLoops(1).SetCodec(srv).SetHandler(srv))
+	go es.Start()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		nc, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = nc.SetDeadline(time.Now().Add(2 * time.Second))
+			return nc, func() {
+				nc.Close()
+				_ = es.Shutdown(context.Background())
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServerErrorResponses(t *testing.T) {
+	tests := []struct {
+		name string
+		req  string
+		code int
+	}{
+		{name: "malformed", req: "GET /\r\nHost: a\r\n\r\n", code: nethttp.StatusBadRequest},
+		{name: "header too large", req: "GET / HTTP/1.1\r\nX: " + strings.Repeat("x", 128) + "\r\n\r\n",
+			code: nethttp.StatusRequestHeaderFieldsTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nc, stop := serve(t, &Server{MaxHeaderBytes: 64})
+			defer stop()
+
+			if _, err := io.WriteString(nc, tt.req); err != nil {
+				t.Fatal(err)
+			}
+			resp, err := nethttp.ReadResponse(bufio.NewReader(nc), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.code || !resp.Close {
+				t.Fatalf("status %d, close %v, want %d and close", resp.StatusCode, resp.Close, tt.code)
+			}
+			// the connection is closed after the response
+			if rest, err := ioutil.ReadAll(nc); err != nil || len(rest) != 0 {
+				t.Fatalf("after the response: %q, %v", rest, err)
+			}
+		})
+	}
+}
+
+func TestServerPipelining(t *testing.T) {
+	mux := nethttp.NewServeMux()
+	mux.HandleFunc("/", func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		_, _ = io.WriteString(w, r.URL.Path)
+	})
+	nc, stop := serve(t, &Server{Handler: mux})
+	defer stop()
+
+	if _, err := io.WriteString(nc, "GET /1 HTTP/1.1\r\nHost: a\r\n\r\nPOST /2 HTTP/1.1\r\nHost: a\r\nContent-Length: 1\r\n\r\nx"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(nc)
+	for _, want := range []string{"/1", "/2"} {
+		resp, err := nethttp.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		if string(b) != want {
+			t.Fatalf("body %q, want %q", b, want)
+		}
+	}
+}
+
+func TestServerConnectionClose(t *testing.T) {
+	served := make(chan string, 2)
+	handler := nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		served <- r.URL.Path
+	})
+	nc, stop := serve(t, &Server{Handler: handler})
+	defer stop()
+
+	// what follows a request answered with Connection: close is not read
+	if _, err := io.WriteString(nc, "GET /1 HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\nGET /2 HTTP/1.1\r\nHost: a\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(nc)
+	resp, err := nethttp.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Close {
+		t.Fatal("response without Connection: close")
+	}
+	if rest, err := ioutil.ReadAll(br); err != nil || len(rest) != 0 {
+		t.Fatalf("after the response: %q, %v", rest, err)
+	}
+	if len(served) != 1 || <-served != "/1" {
+		t.Fatalf("served %d requests, want /1 only", len(served))
+	}
+}
+
+type echoHandler struct{}
+
+func (echoHandler) OnOpen(*websocket.Conn) {}
+func (echoHandler) OnMessage(c *websocket.Conn, op websocket.OpCode, b []byte) {
+	_ = c.WriteMessage(op, b)
+}
+func (echoHandler) OnClose(*websocket.Conn, int, string) {}
+func (echoHandler) OnError(*websocket.Conn, error)       {}
+func (echoHandler) OnPing(*websocket.Conn, []byte)       {}
+func (echoHandler) OnPong(*websocket.Conn, []byte)       {}
+
+func TestServerWebsocketUpgrade(t *testing.T) {
+	srv := &Server{Upgrades: map[string]evnio.ConnectionHandler{
+		"websocket": &websocket.Websocket{Handler: echoHandler{}},
+	}}
+	nc, stop := serve(t, srv)
+	defer stop()
+
+	upgrade := "GET /ws HTTP/1.1\r\nHost: a\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x81, 0x80 | 5, mask[0], mask[1], mask[2], mask[3]}
+	for i, b := range []byte("hello") {
+		frame = append(frame, b^mask[i%4])
+	}
+	// the first frame arrives with the upgrade request, the second split across writes
+	if _, err := nc.Write(append([]byte(upgrade), frame...)); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(nc)
+	resp, err := nethttp.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != nethttp.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("upgrade response %d %v", resp.StatusCode, resp.Header)
+	}
+	readEcho := func() {
+		t.Helper()
+		b := make([]byte, 7)
+		if _, err := io.ReadFull(br, b); err != nil {
+			t.Fatal(err)
+		}
+		if b[0] != 0x81 || b[1] != 5 || string(b[2:]) != "hello" {
+			t.Fatalf("echo frame %q", b)
+		}
+	}
+	readEcho()
+
+	for _, part := range [][]byte{frame[:3], frame[3:]} {
+		if _, err := nc.Write(part); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	readEcho()
+}
@@ -3,33 +3,69 @@
 package evnio
 
 import (
+	"context"
 	"errors"
 	"net"
+	"os"
+	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/dreamans/evnio/util"
+	"golang.org/x/sys/unix"
 
 	"github.com/dreamans/evnio/evlog"
 	"github.com/dreamans/evnio/poller"
 )
 
+const (
+	defaultAcceptBatch = 64
+	minAcceptDelay     = 5 * time.Millisecond
+	maxAcceptDelay     = time.Second
+)
+
 type ListenHandler func(ncfd int, sa syscall.Sockaddr)
 
 type Listener struct {
 	ln             net.Listener
+	file           *os.File
 	fd             int
+	spareFd        int
 	newConnHandler ListenHandler
 	evLoop         *EventLoop
+	acceptBatch    int
+	acceptDelay    time.Duration
+	closed         bool
+	stats          Stats
 }
 
 func NewListener(addr string, evLoop *EventLoop, handler ListenHandler) (*Listener, error) {
+	return newListener(addr, evLoop, handler, false, 0)
+}
+
+// NewReusePortListener binds with SO_REUSEPORT so several listeners can share addr.
+func NewReusePortListener(addr string, evLoop *EventLoop, handler ListenHandler) (*Listener, error) {
+	return newListener(addr, evLoop, handler, true, 0)
+}
+
+// newListener listens on addr, a non-zero unixMode is given to the socket file of a unix
+// listener before it starts listening.
+func newListener(addr string, evLoop *EventLoop, handler ListenHandler, reusePort bool, unixMode os.FileMode) (*Listener, error) {
 	listener := &Listener{
 		newConnHandler: handler,
 		evLoop:         evLoop,
+		acceptBatch:    defaultAcceptBatch,
 	}
 
 	network, addr := util.ParseListenerAddr(addr)
-	ln, err := net.Listen(network, addr)
+	var ln net.Listener
+	var err error
+	if unixMode != 0 && (network == "unix" || network == "unixpacket") && !strings.HasPrefix(addr, "@") {
+		ln, err = listenUnix(network, addr, unixMode)
+	} else {
+		ln, err = listenConfig(reusePort).Listen(context.Background(), network, addr)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -37,9 +73,11 @@ func NewListener(addr string, evLoop *EventLoop, handler ListenHandler) (*Listen
 
 	fd, err := listener.getNonblockFd()
 	if err != nil {
+		_ = ln.Close()
 		return nil, err
 	}
 	listener.fd = fd
+	listener.spareFd = openSpareFd()
 
 	return listener, nil
 }
@@ -48,20 +86,49 @@ func (l *Listener) Fd() int {
 	return l.fd
 }
 
+// SetAcceptBatch caps how many connections are accepted per readiness event.
+func (l *Listener) SetAcceptBatch(n int) {
+	if n > 0 {
+		l.acceptBatch = n
+	}
+}
+
+func (l *Listener) Stats() Stats {
+	return Stats{
+		Accepted:     atomic.LoadUint64(&l.stats.Accepted),
+		AcceptErrors: atomic.LoadUint64(&l.stats.AcceptErrors),
+		FdExhausted:  atomic.LoadUint64(&l.stats.FdExhausted),
+	}
+}
+
 func (l *Listener) EventHandler(fd int, events poller.Event) {
-	if events&poller.EventRead != 0 {
-		ncfd, sa, err := syscall.Accept(fd)
+	if events&poller.EventRead == 0 {
+		return
+	}
+	for i := 0; i < l.acceptBatch && !l.closed; i++ {
+		ncfd, sa, err := accept(fd)
 		if err != nil {
-			if err != syscall.EAGAIN {
+			switch err {
+			case syscall.EAGAIN:
+				return
+			case syscall.EINTR:
+				continue
+			}
+			atomic.AddUint64(&l.stats.AcceptErrors, 1)
+			switch err {
+			case syscall.ECONNABORTED:
+				continue
+			case syscall.EMFILE, syscall.ENFILE:
+				atomic.AddUint64(&l.stats.FdExhausted, 1)
+				l.shedConnection()
+				l.pauseAccept(err)
+			default:
 				evlog.Errorf("[syscall.Accept]: %s", err.Error())
 			}
 			return
 		}
-		if err := syscall.SetNonblock(ncfd, true); err != nil {
-			_ = syscall.Close(ncfd)
-			evlog.Errorf("[syscall.SetNonblock]: %s", err.Error())
-			return
-		}
+		atomic.AddUint64(&l.stats.Accepted, 1)
+		l.acceptDelay = 0
 
 		l.callNewConnHandler(ncfd, sa)
 	}
@@ -69,12 +136,56 @@ func (l *Listener) EventHandler(fd int, events poller.Event) {
 
 func (l *Listener) Close() error {
 	l.evLoop.Trigger(func() {
+		if l.closed {
+			return
+		}
+		l.closed = true
 		_ = l.evLoop.DelFdHandler(l.fd)
+		_ = l.file.Close()
 		_ = l.ln.Close()
+		if l.spareFd >= 0 {
+			_ = syscall.Close(l.spareFd)
+		}
 	})
 	return nil
 }
 
+// shedConnection gives up the reserved fd to accept and drop one pending connection,
+// so the peer sees a close instead of waiting in a backlog we cannot serve.
+func (l *Listener) shedConnection() {
+	if l.spareFd < 0 {
+		return
+	}
+	_ = syscall.Close(l.spareFd)
+	if ncfd, _, err := accept(l.fd); err == nil {
+		_ = syscall.Close(ncfd)
+	}
+	l.spareFd = openSpareFd()
+}
+
+// pauseAccept stops polling the listener for a growing delay while fds are exhausted.
+func (l *Listener) pauseAccept(err error) {
+	if l.acceptDelay == 0 {
+		l.acceptDelay = minAcceptDelay
+	} else if l.acceptDelay *= 2; l.acceptDelay > maxAcceptDelay {
+		l.acceptDelay = maxAcceptDelay
+	}
+	evlog.Errorf("[syscall.Accept]: %s, retrying in %s", err.Error(), l.acceptDelay)
+
+	if err := l.evLoop.poll.Del(l.fd); err != nil {
+		evlog.Errorf("[poll.Del]: %s", err.Error())
+		return
+	}
+	l.evLoop.AfterFunc(l.acceptDelay, func() {
+		if l.closed {
+			return
+		}
+		if err := l.evLoop.poll.AddRead(l.fd); err != nil {
+			evlog.Errorf("[poll.AddRead]: %s", err.Error())
+		}
+	})
+}
+
 func (l *Listener) callNewConnHandler(ncfd int, sa syscall.Sockaddr) {
 	if l.newConnHandler != nil {
 		l.newConnHandler(ncfd, sa)
@@ -82,14 +193,20 @@ func (l *Listener) callNewConnHandler(ncfd int, sa syscall.Sockaddr) {
 }
 
 func (l *Listener) getNonblockFd() (int, error) {
-	tcpln, ok := l.ln.(*net.TCPListener)
-	if !ok {
+	var file *os.File
+	var err error
+	switch ln := l.ln.(type) {
+	case *net.TCPListener:
+		file, err = ln.File()
+	case *net.UnixListener:
+		file, err = ln.File()
+	default:
 		return 0, errors.New("could not get file descriptor")
 	}
-	file, err := tcpln.File()
 	if err != nil {
 		return 0, err
 	}
+	l.file = file
 	fd := int(file.Fd())
 	if err = syscall.SetNonblock(fd, true); err != nil {
 		return 0, err
@@ -97,3 +214,63 @@ func (l *Listener) getNonblockFd() (int, error) {
 
 	return fd, nil
 }
+
+func listenConfig(reusePort bool) *net.ListenConfig {
+	lc := &net.ListenConfig{}
+	if reusePort {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			var err error
+			if e := c.Control(func(fd uintptr) {
+				err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			}); e != nil {
+				return e
+			}
+			return err
+		}
+	}
+	return lc
+}
+
+// listenUnix binds a unix socket and chmods its file before listen, so no connection is
+// accepted while the file still has the permissions the umask gave it.
+func listenUnix(network, addr string, mode os.FileMode) (net.Listener, error) {
+	sotype := syscall.SOCK_STREAM
+	if network == "unixpacket" {
+		sotype = syscall.SOCK_SEQPACKET
+	}
+	fd, err := syscall.Socket(syscall.AF_UNIX, sotype, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	syscall.CloseOnExec(fd)
+	f := os.NewFile(uintptr(fd), addr)
+	defer f.Close()
+
+	if err := syscall.Bind(fd, &syscall.SockaddrUnix{Name: addr}); err != nil {
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if err := os.Chmod(addr, mode); err != nil {
+		_ = os.Remove(addr)
+		return nil, err
+	}
+	if err := syscall.Listen(fd, unix.SOMAXCONN); err != nil {
+		_ = os.Remove(addr)
+		return nil, os.NewSyscallError("listen", err)
+	}
+	ln, err := net.FileListener(f)
+	if err != nil {
+		_ = os.Remove(addr)
+		return nil, err
+	}
+	// like a listener from net.Listen, closing it removes the socket file
+	ln.(*net.UnixListener).SetUnlinkOnClose(true)
+	return ln, nil
+}
+
+func openSpareFd() int {
+	fd, err := syscall.Open("/dev/null", syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1
+	}
+	return fd
+}
@@ -0,0 +1,205 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dreamans/evnio/poller"
+)
+
+type credHandler struct {
+	echoHandler
+	creds chan error
+	cred  *PeerCred
+}
+
+func (h *credHandler) OnOpen(c Connection) {
+	cred, err := c.PeerCred()
+	h.cred = cred
+	h.creds <- err
+}
+
+func TestUnixListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evnio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "s.sock")
+
+	h := &credHandler{creds: make(chan error, 1)}
+	srv := NewServer(NewOptions().SetAddr("unix://" + path).SetNumLoops(1).SetUnixSocketMode(0600).SetHandler(h))
+	go srv.Start()
+	listenAddr(t, srv)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0600 {
+		t.Fatalf("socket mode = %v, want 0600", mode)
+	}
+
+	nc, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	err = <-h.creds
+	if runtime.GOOS == "linux" {
+		if err != nil || int(h.cred.Pid) != os.Getpid() || int(h.cred.Uid) != os.Getuid() {
+			t.Fatalf("PeerCred() = %+v, %v, want this process", h.cred, err)
+		}
+	} else if err != ErrNotSupported {
+		t.Fatalf("PeerCred() = %v, want ErrNotSupported", err)
+	}
+
+	_ = nc.SetDeadline(time.Now().Add(time.Second))
+	if _, err := nc.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := nc.Read(buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo = %q, %v", buf, err)
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file left after Shutdown: %v", err)
+	}
+}
+
+func TestPeerCredNotUnix(t *testing.T) {
+	h := &credHandler{creds: make(chan error, 1)}
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(1).SetHandler(h))
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+
+	nc, err := net.Dial("tcp", listenAddr(t, srv).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	if err := <-h.creds; err != ErrNotUnixSocket {
+		t.Fatalf("PeerCred() = %v, want ErrNotUnixSocket", err)
+	}
+}
+
+type loopHandler struct {
+	nopHandler
+	loops chan *EventLoop
+}
+
+func (h *loopHandler) OnOpen(c Connection) {
+	h.loops <- c.(*conn).evLoop
+}
+
+func TestReusePortListenerPerLoop(t *testing.T) {
+	const numLoops, numConns = 3, 30
+	h := &loopHandler{loops: make(chan *EventLoop, numConns)}
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(numLoops).SetReusePort(true).SetHandler(h))
+	loops := srv.EventLoops()
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+	addr := listenAddr(t, srv)
+
+	s := srv.(*server)
+	s.mu.Lock()
+	listeners := s.listeners
+	s.mu.Unlock()
+	if len(listeners) != numLoops {
+		t.Fatalf("%d listeners, want one per loop", len(listeners))
+	}
+	for i, l := range listeners {
+		if l.evLoop != loops[i] {
+			t.Errorf("listener %d is not on worker loop %d", i, i)
+		}
+		// the port picked for ":0" is shared by every listener
+		if l.ln.Addr().String() != addr.String() {
+			t.Errorf("listener %d on %s, want %s", i, l.ln.Addr(), addr)
+		}
+	}
+
+	for i := 0; i < numConns; i++ {
+		nc, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer nc.Close()
+	}
+	// each listener serves what it accepts on its own loop, the kernel spreads the connections
+	used := make(map[*EventLoop]bool)
+	for i := 0; i < numConns; i++ {
+		select {
+		case loop := <-h.loops:
+			used[loop] = true
+		case <-time.After(time.Second):
+			t.Fatalf("%d of %d connections opened", i, numConns)
+		}
+	}
+	if len(used) < 2 {
+		t.Fatalf("the kernel spread %d connections over %d loops", numConns, len(used))
+	}
+}
+
+func TestReusePortRequiresTCPOrUDP(t *testing.T) {
+	srv := NewServer(NewOptions().SetAddr("unix:///tmp/evnio-reuseport.sock").SetNumLoops(1).SetReusePort(true).SetHandler(nopHandler{}))
+	defer srv.Shutdown(context.Background())
+	if err := srv.Start(); err != errReusePortNetwork {
+		t.Fatalf("Start() = %v, want errReusePortNetwork", err)
+	}
+}
+
+func TestListenerAcceptBatch(t *testing.T) {
+	ev, err := newEventLoop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ev.Wait()
+	defer ev.Stop()
+
+	var accepted []int
+	l, err := NewListener("tcp://127.0.0.1:0", ev, func(ncfd int, sa syscall.Sockaddr) {
+		accepted = append(accepted, ncfd)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	defer func() {
+		for _, fd := range accepted {
+			_ = syscall.Close(fd)
+		}
+	}()
+	l.SetAcceptBatch(4)
+
+	for i := 0; i < 10; i++ {
+		nc, err := net.Dial("tcp", l.ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer nc.Close()
+	}
+
+	// one readiness event accepts up to the batch, the rest waits for the next
+	for _, want := range []int{4, 8, 10, 10} {
+		l.EventHandler(l.Fd(), poller.EventRead)
+		if len(accepted) != want {
+			t.Fatalf("accepted %d connections, want %d", len(accepted), want)
+		}
+	}
+	if stats := l.Stats(); stats.Accepted != 10 || stats.AcceptErrors != 0 {
+		t.Fatalf("Stats() = %+v", stats)
+	}
+}
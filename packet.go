@@ -0,0 +1,24 @@
+package evnio
+
+import (
+	"errors"
+	"net"
+)
+
+var ErrPacketAddr = errors.New("evnio: packet address must be a *net.UDPAddr")
+
+// PacketConn is a datagram socket served by an EventLoop.
+type PacketConn interface {
+	LocalAddr() net.Addr
+
+	// SendTo queues data as a single datagram to addr, data must not be modified afterwards.
+	SendTo(addr net.Addr, data []byte) error
+
+	Close() error
+}
+
+// PacketHandler receives datagrams of a udp:// server on the loop goroutine,
+// data is only valid until OnPacket returns.
+type PacketHandler interface {
+	OnPacket(c PacketConn, addr net.Addr, data []byte)
+}
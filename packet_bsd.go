@@ -0,0 +1,64 @@
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/dreamans/evnio/util"
+)
+
+// packetBatch falls back to one recvfrom/sendto per datagram.
+type packetBatch struct {
+	bufs  [][]byte
+	lens  []int
+	addrs []*net.UDPAddr
+}
+
+func newPacketBatch() *packetBatch {
+	b := &packetBatch{
+		bufs:  make([][]byte, packetBatchSize),
+		lens:  make([]int, packetBatchSize),
+		addrs: make([]*net.UDPAddr, packetBatchSize),
+	}
+	for i := range b.bufs {
+		b.bufs[i] = make([]byte, maxPacketSize)
+	}
+	return b
+}
+
+func (b *packetBatch) read(fd int) (int, error) {
+	for i := range b.bufs {
+		n, sa, err := syscall.Recvfrom(fd, b.bufs[i], 0)
+		if err != nil {
+			if i > 0 {
+				return i, nil
+			}
+			return 0, err
+		}
+		b.lens[i] = n
+		if addr, ok := util.SockAddrToAddr(sa).(*net.TCPAddr); ok {
+			b.addrs[i] = &net.UDPAddr{IP: addr.IP, Port: addr.Port, Zone: addr.Zone}
+		} else {
+			b.addrs[i] = nil
+		}
+	}
+	return len(b.bufs), nil
+}
+
+func (b *packetBatch) packet(i int) (*net.UDPAddr, []byte) {
+	return b.addrs[i], b.bufs[i][:b.lens[i]]
+}
+
+func (b *packetBatch) write(fd int, family int, pkts []outPacket) (int, error) {
+	for i, pkt := range pkts {
+		if err := syscall.Sendto(fd, pkt.data, 0, udpSockAddr(family, pkt.addr)); err != nil {
+			if i > 0 {
+				return i, nil
+			}
+			return 0, err
+		}
+	}
+	return len(pkts), nil
+}
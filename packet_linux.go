@@ -0,0 +1,138 @@
+// +build linux
+
+package evnio
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+type mmsghdr struct {
+	Hdr syscall.Msghdr
+	Len uint32
+	_   [unsafe.Sizeof(uintptr(0)) - 4]byte
+}
+
+// packetBatch moves up to packetBatchSize datagrams per recvmmsg/sendmmsg call.
+type packetBatch struct {
+	bufs   [][]byte
+	names  []syscall.RawSockaddrAny
+	iovs   []syscall.Iovec
+	hdrs   []mmsghdr
+	wnames []syscall.RawSockaddrAny
+	wiovs  []syscall.Iovec
+	whdrs  []mmsghdr
+}
+
+func newPacketBatch() *packetBatch {
+	b := &packetBatch{
+		bufs:   make([][]byte, packetBatchSize),
+		names:  make([]syscall.RawSockaddrAny, packetBatchSize),
+		iovs:   make([]syscall.Iovec, packetBatchSize),
+		hdrs:   make([]mmsghdr, packetBatchSize),
+		wnames: make([]syscall.RawSockaddrAny, packetBatchSize),
+		wiovs:  make([]syscall.Iovec, packetBatchSize),
+		whdrs:  make([]mmsghdr, packetBatchSize),
+	}
+	for i := range b.bufs {
+		b.bufs[i] = make([]byte, maxPacketSize)
+		b.iovs[i].Base = &b.bufs[i][0]
+		b.iovs[i].SetLen(len(b.bufs[i]))
+		b.hdrs[i].Hdr.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+		b.hdrs[i].Hdr.Iov = &b.iovs[i]
+		b.hdrs[i].Hdr.Iovlen = 1
+		b.whdrs[i].Hdr.Name = (*byte)(unsafe.Pointer(&b.wnames[i]))
+		b.whdrs[i].Hdr.Iov = &b.wiovs[i]
+		b.whdrs[i].Hdr.Iovlen = 1
+	}
+	return b
+}
+
+func (b *packetBatch) read(fd int) (int, error) {
+	for i := range b.hdrs {
+		b.hdrs[i].Hdr.Namelen = syscall.SizeofSockaddrAny
+	}
+	n, _, e := syscall.Syscall6(unix.SYS_RECVMMSG, uintptr(fd), uintptr(unsafe.Pointer(&b.hdrs[0])), uintptr(len(b.hdrs)), 0, 0, 0)
+	if e != 0 {
+		return 0, e
+	}
+	return int(n), nil
+}
+
+func (b *packetBatch) packet(i int) (*net.UDPAddr, []byte) {
+	return rawToUDPAddr(&b.names[i]), b.bufs[i][:b.hdrs[i].Len]
+}
+
+func (b *packetBatch) write(fd int, family int, pkts []outPacket) (int, error) {
+	if len(pkts) > len(b.whdrs) {
+		pkts = pkts[:len(b.whdrs)]
+	}
+	for i, pkt := range pkts {
+		b.whdrs[i].Hdr.Namelen = udpAddrToRaw(family, pkt.addr, &b.wnames[i])
+		if len(pkt.data) > 0 {
+			b.wiovs[i].Base = &pkt.data[0]
+		} else {
+			b.wiovs[i].Base = nil
+		}
+		b.wiovs[i].SetLen(len(pkt.data))
+	}
+	n, _, e := syscall.Syscall6(unix.SYS_SENDMMSG, uintptr(fd), uintptr(unsafe.Pointer(&b.whdrs[0])), uintptr(len(pkts)), 0, 0, 0)
+	for i := range pkts {
+		b.wiovs[i].Base = nil
+	}
+	if e != 0 {
+		return 0, e
+	}
+	return int(n), nil
+}
+
+func rawToUDPAddr(rsa *syscall.RawSockaddrAny) *net.UDPAddr {
+	switch rsa.Addr.Family {
+	case syscall.AF_INET:
+		pp := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		return &net.UDPAddr{
+			IP:   append(net.IP{}, pp.Addr[:]...),
+			Port: int(p[0])<<8 + int(p[1]),
+		}
+	case syscall.AF_INET6:
+		pp := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		addr := &net.UDPAddr{
+			IP:   append(net.IP{}, pp.Addr[:]...),
+			Port: int(p[0])<<8 + int(p[1]),
+		}
+		if pp.Scope_id != 0 {
+			if ifi, err := net.InterfaceByIndex(int(pp.Scope_id)); err == nil {
+				addr.Zone = ifi.Name
+			}
+		}
+		return addr
+	}
+	return nil
+}
+
+func udpAddrToRaw(family int, addr *net.UDPAddr, rsa *syscall.RawSockaddrAny) uint32 {
+	switch sa := udpSockAddr(family, addr).(type) {
+	case *syscall.SockaddrInet4:
+		pp := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		pp.Family = syscall.AF_INET
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		p[0], p[1] = byte(sa.Port>>8), byte(sa.Port)
+		pp.Addr = sa.Addr
+		return syscall.SizeofSockaddrInet4
+	case *syscall.SockaddrInet6:
+		pp := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		pp.Family = syscall.AF_INET6
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		p[0], p[1] = byte(sa.Port>>8), byte(sa.Port)
+		pp.Flowinfo = 0
+		pp.Addr = sa.Addr
+		pp.Scope_id = sa.ZoneId
+		return syscall.SizeofSockaddrInet6
+	}
+	return 0
+}
@@ -0,0 +1,200 @@
+// +build linux
+
+package evnio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type packetEchoHandler struct{}
+
+func (packetEchoHandler) OnPacket(c PacketConn, addr net.Addr, data []byte) {
+	_ = c.SendTo(addr, append([]byte(nil), data...))
+}
+
+func TestPacketBatchReadsSeveralDatagrams(t *testing.T) {
+	pc, err := newPacketConn("udp://127.0.0.1:0", nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.ln.Close()
+	defer pc.file.Close()
+
+	client, err := net.DialUDP("udp", nil, pc.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for i := 0; i < 5; i++ {
+		if _, err := client.Write([]byte(fmt.Sprintf("datagram %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := pc.batch.read(pc.fd)
+	if err != nil || n != 5 {
+		t.Fatalf("read = %d, %v, want 5 datagrams in one call", n, err)
+	}
+	from := client.LocalAddr().String()
+	for i := 0; i < n; i++ {
+		addr, data := pc.batch.packet(i)
+		if addr.String() != from || string(data) != fmt.Sprintf("datagram %d", i) {
+			t.Fatalf("packet %d = %q from %s, want from %s", i, data, addr, from)
+		}
+	}
+	if _, err := pc.batch.read(pc.fd); err != syscall.EAGAIN {
+		t.Fatalf("read of an empty socket = %v, want EAGAIN", err)
+	}
+}
+
+func TestPacketSendToReachesPeer(t *testing.T) {
+	for _, network := range []string{"udp4", "udp6"} {
+		t.Run(network, func(t *testing.T) {
+			host := "127.0.0.1"
+			if network == "udp6" {
+				host = "[::1]"
+				if ln, err := net.ListenPacket("udp6", "[::1]:0"); err != nil {
+					t.Skip("no IPv6 loopback")
+				} else {
+					ln.Close()
+				}
+			}
+			srv := NewServer(NewOptions().SetAddr("udp://" + host + ":0").SetNumLoops(1).SetPacketHandler(packetEchoHandler{}))
+			go srv.Start()
+			defer srv.Shutdown(context.Background())
+			addr := listenAddr(t, srv).(*net.UDPAddr)
+
+			// replies must go back to whichever peer sent each datagram
+			var clients []*net.UDPConn
+			for i := 0; i < 3; i++ {
+				client, err := net.DialUDP(network, nil, addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer client.Close()
+				clients = append(clients, client)
+			}
+			for i, client := range clients {
+				if _, err := client.Write([]byte(fmt.Sprintf("peer %d", i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			buf := make([]byte, 64)
+			for i, client := range clients {
+				_ = client.SetReadDeadline(time.Now().Add(time.Second))
+				n, err := client.Read(buf)
+				if err != nil || string(buf[:n]) != fmt.Sprintf("peer %d", i) {
+					t.Fatalf("peer %d read %q, %v", i, buf[:n], err)
+				}
+			}
+		})
+	}
+}
+
+func TestPacketAddrRoundTrip(t *testing.T) {
+	lo, err := net.InterfaceByIndex(1)
+	if err != nil {
+		t.Skip("no interface 1")
+	}
+	tests := []struct {
+		family int
+		addr   *net.UDPAddr
+		want   string
+	}{
+		{syscall.AF_INET, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53}, "192.0.2.1:53"},
+		{syscall.AF_INET6, &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}, "[2001:db8::1]:443"},
+		{syscall.AF_INET6, &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 8, Zone: lo.Name}, "[fe80::1%" + lo.Name + "]:8"},
+		// an IPv6 socket sends to IPv4 peers through mapped addresses
+		{syscall.AF_INET6, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53}, "192.0.2.1:53"},
+	}
+	for _, tt := range tests {
+		var rsa syscall.RawSockaddrAny
+		if udpAddrToRaw(tt.family, tt.addr, &rsa) == 0 {
+			t.Fatalf("udpAddrToRaw(%s) failed", tt.addr)
+		}
+		if got := rawToUDPAddr(&rsa); got == nil || got.String() != tt.want {
+			t.Errorf("round trip of %s = %v, want %s", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestPacketWriteRequeuesOnEAGAIN(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	peer, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	nc, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	// sendmmsg on a stream socket ignores the addresses, so a full send buffer
+	// stands in for a datagram socket the kernel can't take more from
+	f, err := nc.(*net.TCPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		t.Fatal(err)
+	}
+	_ = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, 4096)
+	filled := 0
+	chunk := make([]byte, 4096)
+	for {
+		n, err := syscall.Write(fd, chunk)
+		if err == syscall.EAGAIN {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		filled += n
+	}
+
+	ev, err := newEventLoop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ev.Wait()
+	defer ev.Stop()
+	if err := ev.poll.AddRead(fd); err != nil {
+		t.Fatal(err)
+	}
+	to := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+	pc := &packetConn{fd: fd, family: syscall.AF_INET, evLoop: ev, batch: newPacketBatch()}
+	pc.outq = []outPacket{{to, []byte("one")}, {to, []byte("two")}, {to, []byte("three")}}
+
+	pc.handleWrite()
+	if len(pc.outq) != 3 {
+		t.Fatalf("%d datagrams left after EAGAIN, want all 3", len(pc.outq))
+	}
+
+	_ = peer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(peer, make([]byte, filled)); err != nil {
+		t.Fatal(err)
+	}
+	pc.handleWrite()
+	if len(pc.outq) != 0 {
+		t.Fatalf("%d datagrams left once writable", len(pc.outq))
+	}
+	got := make([]byte, len("onetwothree"))
+	if _, err := io.ReadFull(peer, got); err != nil || !bytes.Equal(got, []byte("onetwothree")) {
+		t.Fatalf("peer read %q, %v", got, err)
+	}
+}
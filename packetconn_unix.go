@@ -0,0 +1,183 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/dreamans/evnio/evlog"
+	"github.com/dreamans/evnio/poller"
+	"github.com/dreamans/evnio/util"
+)
+
+const (
+	packetBatchSize = 16
+	maxPacketSize   = 0xFFFF
+)
+
+type outPacket struct {
+	addr *net.UDPAddr
+	data []byte
+}
+
+type packetConn struct {
+	ln        net.PacketConn
+	file      *os.File
+	fd        int
+	family    int
+	evLoop    *EventLoop
+	handler   PacketHandler
+	localAddr net.Addr
+	batch     *packetBatch
+	outq      []outPacket
+	closed    util.AtomicBool
+}
+
+func newPacketConn(addr string, evLoop *EventLoop, handler PacketHandler, reusePort bool) (*packetConn, error) {
+	network, addr := util.ParseListenerAddr(addr)
+	ln, err := listenConfig(reusePort).ListenPacket(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+	udpln, ok := ln.(*net.UDPConn)
+	if !ok {
+		_ = ln.Close()
+		return nil, errors.New("could not get file descriptor")
+	}
+	file, err := udpln.File()
+	if err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	fd := int(file.Fd())
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		_ = file.Close()
+		_ = ln.Close()
+		return nil, err
+	}
+
+	pc := &packetConn{
+		ln:        ln,
+		file:      file,
+		fd:        fd,
+		family:    syscall.AF_INET,
+		evLoop:    evLoop,
+		handler:   handler,
+		localAddr: ln.LocalAddr(),
+		batch:     newPacketBatch(),
+	}
+	if sa, err := syscall.Getsockname(fd); err == nil {
+		pc.family = util.SockAddrFamily(sa)
+	}
+	return pc, nil
+}
+
+func (pc *packetConn) Fd() int {
+	return pc.fd
+}
+
+func (pc *packetConn) LocalAddr() net.Addr {
+	return pc.localAddr
+}
+
+func (pc *packetConn) SendTo(addr net.Addr, data []byte) error {
+	if pc.closed.IsSet() {
+		return ErrConnectionClosed
+	}
+	uaddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return ErrPacketAddr
+	}
+	pc.evLoop.Trigger(func() {
+		if pc.closed.IsSet() {
+			return
+		}
+		if len(pc.outq) == 0 {
+			if err := pc.evLoop.EnableReadWrite(pc.fd); err != nil {
+				evlog.Errorf("[evLoop.EnableReadWrite]: %s", err.Error())
+			}
+		}
+		pc.outq = append(pc.outq, outPacket{addr: uaddr, data: data})
+	})
+	return nil
+}
+
+func (pc *packetConn) Close() error {
+	if pc.closed.IsSet() {
+		return ErrConnectionClosed
+	}
+	pc.evLoop.Trigger(pc.handleClose)
+	return nil
+}
+
+func (pc *packetConn) EventHandler(fd int, events poller.Event) {
+	if events&poller.EventRead != 0 {
+		pc.handleRead()
+	}
+	if events&poller.EventWrite != 0 {
+		pc.handleWrite()
+	}
+}
+
+func (pc *packetConn) handleClose() {
+	if pc.closed.IsSet() {
+		return
+	}
+	pc.closed.Set()
+	pc.outq = nil
+
+	if err := pc.evLoop.DelFdHandler(pc.fd); err != nil {
+		evlog.Errorf("[evLoop.DelFdHandler]: %s", err.Error())
+	}
+	_ = pc.file.Close()
+	_ = pc.ln.Close()
+}
+
+func (pc *packetConn) handleRead() {
+	n, err := pc.batch.read(pc.fd)
+	if err != nil {
+		if err != syscall.EAGAIN {
+			evlog.Errorf("[packetConn.read]: %s", err.Error())
+		}
+		return
+	}
+	for i := 0; i < n && !pc.closed.IsSet(); i++ {
+		addr, data := pc.batch.packet(i)
+		pc.handler.OnPacket(pc, addr, data)
+	}
+}
+
+func (pc *packetConn) handleWrite() {
+	for len(pc.outq) > 0 {
+		n, err := pc.batch.write(pc.fd, pc.family, pc.outq)
+		if err == syscall.EAGAIN {
+			return
+		}
+		if err != nil {
+			// drop the datagram the kernel refused and carry on with the rest
+			evlog.Errorf("[packetConn.write]: %s, to %s", err.Error(), pc.outq[0].addr)
+			n = 1
+		}
+		pc.outq = pc.outq[n:]
+	}
+	pc.outq = nil
+
+	if err := pc.evLoop.EnableRead(pc.fd); err != nil {
+		evlog.Errorf("[evLoop.EnableRead]: %s", err.Error())
+	}
+}
+
+func udpSockAddr(family int, addr *net.UDPAddr) syscall.Sockaddr {
+	sa := util.IPToSockAddr(addr.IP, addr.Port, addr.Zone)
+	if _, ok := sa.(*syscall.SockaddrInet4); ok && family == syscall.AF_INET6 {
+		// an IPv6 socket reaches IPv4 peers through mapped addresses
+		sa6 := &syscall.SockaddrInet6{Port: addr.Port}
+		copy(sa6.Addr[:], addr.IP.To16())
+		return sa6
+	}
+	return sa
+}
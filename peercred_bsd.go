@@ -0,0 +1,7 @@
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+func getPeerCred(fd int) (*PeerCred, error) {
+	return nil, ErrNotSupported
+}
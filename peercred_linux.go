@@ -0,0 +1,13 @@
+// +build linux
+
+package evnio
+
+import "syscall"
+
+func getPeerCred(fd int) (*PeerCred, error) {
+	ucred, err := syscall.GetsockoptUcred(fd, syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	if err != nil {
+		return nil, err
+	}
+	return &PeerCred{Pid: ucred.Pid, Uid: ucred.Uid, Gid: ucred.Gid}, nil
+}
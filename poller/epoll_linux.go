@@ -15,17 +15,16 @@ const (
 	writeEvent = syscall.EPOLLOUT
 )
 
-var (
-	wakeWriteBytes = []byte{1, 0, 0, 0, 0, 0, 0, 0}
-	wakeBuf        = make([]byte, 8)
-)
+var wakeWriteBytes = []byte{1, 0, 0, 0, 0, 0, 0, 0}
 
 type Epoll struct {
 	fd        int
 	eventFd   int
 	handler   EventHandler
+	timeout   TimeoutHandler
 	closed    util.AtomicBool
 	closeDone chan struct{}
+	wakeBuf   [8]byte
 }
 
 func New(handler EventHandler) (Poller, error) {
@@ -64,7 +63,7 @@ func (ep *Epoll) Trigger() error {
 }
 
 func (ep *Epoll) triggerHandlerRead() {
-	_, _ = syscall.Read(ep.eventFd, wakeBuf)
+	_, _ = syscall.Read(ep.eventFd, ep.wakeBuf[:])
 }
 
 func (ep *Epoll) AddRead(fd int) error {
@@ -79,10 +78,22 @@ func (ep *Epoll) EnableRead(fd int) error {
 	return ep.mod(fd, readEvent)
 }
 
+func (ep *Epoll) EnableWrite(fd int) error {
+	return ep.mod(fd, writeEvent)
+}
+
+func (ep *Epoll) Disable(fd int) error {
+	return ep.mod(fd, 0)
+}
+
 func (ep *Epoll) Del(fd int) error {
 	return syscall.EpollCtl(ep.fd, syscall.EPOLL_CTL_DEL, fd, nil)
 }
 
+func (ep *Epoll) SetTimeoutHandler(handler TimeoutHandler) {
+	ep.timeout = handler
+}
+
 func (ep *Epoll) Close() error {
 	if ep.closed.IsSet() {
 		return ErrClosed
@@ -105,7 +116,8 @@ func (ep *Epoll) Wait() {
 
 	var tempDelay time.Duration
 	for {
-		n, err := syscall.EpollWait(ep.fd, events, -1)
+		msec := ep.waitMsec()
+		n, err := syscall.EpollWait(ep.fd, events, msec)
 
 		if err != nil && !util.TemporaryErr(err) {
 			if tempDelay == 0 {
@@ -142,7 +154,7 @@ func (ep *Epoll) Wait() {
 				trigger = true
 			}
 		}
-		if trigger {
+		if trigger || msec >= 0 {
 			ep.handler(-1, 0)
 			if ep.closed.IsSet() {
 				return
@@ -155,6 +167,17 @@ func (ep *Epoll) Wait() {
 	}
 }
 
+func (ep *Epoll) waitMsec() int {
+	if ep.timeout == nil {
+		return -1
+	}
+	d := ep.timeout()
+	if d < 0 {
+		return -1
+	}
+	return int((d + time.Millisecond - 1) / time.Millisecond)
+}
+
 func (ep *Epoll) add(fd int, events Event) error {
 	ev := &syscall.EpollEvent{
 		Fd:     int32(fd),
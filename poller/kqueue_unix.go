@@ -12,6 +12,7 @@ import (
 
 type KQueue struct {
 	handler   EventHandler
+	timeout   TimeoutHandler
 	fd        int
 	closed    util.AtomicBool
 	closeDone chan struct{}
@@ -61,15 +62,33 @@ func (kq *KQueue) AddRead(fd int) error {
 }
 
 func (kq *KQueue) EnableReadWrite(fd int) error {
-	_, err := syscall.Kevent(kq.fd, []syscall.Kevent_t{
-		{Ident: uint64(fd), Flags: syscall.EV_ADD, Filter: syscall.EVFILT_WRITE},
-	}, nil, nil)
-	return err
+	return kq.enable(fd, true, true)
 }
 
 func (kq *KQueue) EnableRead(fd int) error {
+	return kq.enable(fd, true, false)
+}
+
+func (kq *KQueue) EnableWrite(fd int) error {
+	return kq.enable(fd, false, true)
+}
+
+func (kq *KQueue) Disable(fd int) error {
+	return kq.enable(fd, false, false)
+}
+
+// enable switches both filters with EV_ENABLE/EV_DISABLE, EV_ADD keeps this valid
+// for a filter that was never registered.
+func (kq *KQueue) enable(fd int, read, write bool) error {
+	flags := func(on bool) uint16 {
+		if on {
+			return syscall.EV_ADD | syscall.EV_ENABLE
+		}
+		return syscall.EV_ADD | syscall.EV_DISABLE
+	}
 	_, err := syscall.Kevent(kq.fd, []syscall.Kevent_t{
-		{Ident: uint64(fd), Flags: syscall.EV_DELETE, Filter: syscall.EVFILT_WRITE},
+		{Ident: uint64(fd), Flags: flags(read), Filter: syscall.EVFILT_READ},
+		{Ident: uint64(fd), Flags: flags(write), Filter: syscall.EVFILT_WRITE},
 	}, nil, nil)
 	return err
 }
@@ -81,6 +100,10 @@ func (kq *KQueue) Del(fd int) error {
 	return err
 }
 
+func (kq *KQueue) SetTimeoutHandler(handler TimeoutHandler) {
+	kq.timeout = handler
+}
+
 func (kq *KQueue) Wait() {
 	defer func() {
 		close(kq.closeDone)
@@ -91,7 +114,8 @@ func (kq *KQueue) Wait() {
 
 	var tempDelay time.Duration
 	for {
-		n, err := syscall.Kevent(kq.fd, nil, events, nil)
+		ts := kq.waitTimespec()
+		n, err := syscall.Kevent(kq.fd, nil, events, ts)
 
 		if err != nil && !util.TemporaryErr(err) {
 			if tempDelay == 0 {
@@ -127,7 +151,7 @@ func (kq *KQueue) Wait() {
 				trigger = true
 			}
 		}
-		if trigger {
+		if trigger || ts != nil {
 			kq.handler(-1, 0)
 			if kq.closed.IsSet() {
 				return
@@ -140,6 +164,18 @@ func (kq *KQueue) Wait() {
 	}
 }
 
+func (kq *KQueue) waitTimespec() *syscall.Timespec {
+	if kq.timeout == nil {
+		return nil
+	}
+	d := kq.timeout()
+	if d < 0 {
+		return nil
+	}
+	ts := syscall.NsecToTimespec(int64(d))
+	return &ts
+}
+
 func (kq *KQueue) Close() (err error) {
 	if kq.closed.IsSet() {
 		return ErrClosed
@@ -1,11 +1,17 @@
 package poller
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 type (
 	Event uint32
 
 	EventHandler func(fd int, event Event)
+
+	// TimeoutHandler returns how long Wait may block, a negative value blocks until an event arrives.
+	TimeoutHandler func() time.Duration
 )
 
 const (
@@ -26,7 +32,12 @@ type Poller interface {
 	AddRead(fd int) error
 	EnableRead(fd int) error
 	EnableReadWrite(fd int) error
+	// EnableWrite waits for writability only, reads are left in the kernel buffer.
+	EnableWrite(fd int) error
+	// Disable stops waiting on fd, errors and hangups may still be reported.
+	Disable(fd int) error
 	Del(fd int) error
+	SetTimeoutHandler(handler TimeoutHandler)
 	Wait()
 	Trigger() error
 	Close() error
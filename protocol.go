@@ -2,6 +2,7 @@ package evnio
 
 import (
 	"bytes"
+	"net"
 )
 
 type Protocol interface {
@@ -9,6 +10,19 @@ type Protocol interface {
 	Packet(Connection, []byte) []byte
 }
 
+// BuffersProtocol may be implemented by a Protocol to pack the buffers given to
+// SendBuffers without joining them, a Protocol without it gets them joined into Packet.
+type BuffersProtocol interface {
+	PacketBuffers(Connection, net.Buffers) net.Buffers
+}
+
+func packetBuffers(p Protocol, c Connection, buffers net.Buffers) net.Buffers {
+	if bp, ok := p.(BuffersProtocol); ok {
+		return bp.PacketBuffers(c, buffers)
+	}
+	return net.Buffers{p.Packet(c, bytes.Join(buffers, nil))}
+}
+
 type defaultProtocol struct{}
 
 func (d *defaultProtocol) UnPacket(c Connection, buffer *bytes.Buffer) []byte {
@@ -20,3 +34,7 @@ func (d *defaultProtocol) UnPacket(c Connection, buffer *bytes.Buffer) []byte {
 func (d *defaultProtocol) Packet(c Connection, data []byte) []byte {
 	return data
 }
+
+func (d *defaultProtocol) PacketBuffers(c Connection, buffers net.Buffers) net.Buffers {
+	return buffers
+}
@@ -0,0 +1,252 @@
+package evnio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProxyProtocolMode selects how accepted connections treat a HAProxy PROXY protocol header.
+type ProxyProtocolMode uint8
+
+const (
+	// ProxyProtocolOff leaves the stream untouched.
+	ProxyProtocolOff ProxyProtocolMode = iota
+	// ProxyProtocolOptional parses a header when the stream starts with one.
+	ProxyProtocolOptional
+	// ProxyProtocolRequired closes connections that don't start with a header.
+	ProxyProtocolRequired
+)
+
+const (
+	ProxyHeaderContextKey = "proxy-header-context-key"
+)
+
+// PROXY protocol v2 TLV types.
+const (
+	ProxyTLVALPN      byte = 0x01
+	ProxyTLVAuthority byte = 0x02
+	ProxyTLVCRC32C    byte = 0x03
+	ProxyTLVNoop      byte = 0x04
+	ProxyTLVUniqueID  byte = 0x05
+	ProxyTLVSSL       byte = 0x20
+	ProxyTLVNetNS     byte = 0x30
+)
+
+var (
+	ErrProxyHeader        = errors.New("evnio: invalid PROXY protocol header")
+	ErrProxyHeaderTimeout = errors.New("evnio: PROXY protocol header timeout")
+)
+
+const defaultProxyHeaderTimeout = 5 * time.Second
+
+var errNoProxyHeader = errors.New("evnio: no PROXY protocol header")
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	proxyV1MaxLen    = 107
+	proxyV2HeaderLen = 16
+)
+
+// ProxyHeader is a parsed PROXY protocol header, it is stored in the connection
+// context under ProxyHeaderContextKey.
+type ProxyHeader struct {
+	Version int
+	// Local is set for v2 LOCAL commands and v1 UNKNOWN headers, the addresses are then absent.
+	Local bool
+	// SourceAddr and DestAddr replace RemoteAddr and LocalAddr only when they are TCP
+	// addresses, UNIX and UDP addresses don't describe the stream so the connection keeps
+	// the proxy's.
+	SourceAddr net.Addr
+	DestAddr   net.Addr
+	TLVs       []ProxyTLV
+}
+
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// TLV returns the value of the first TLV of type t.
+func (h *ProxyHeader) TLV(t byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// parseProxyHeader parses the header at the start of b and returns how many bytes it took,
+// zero with a nil error means more data is needed.
+func parseProxyHeader(b []byte) (*ProxyHeader, int, error) {
+	switch {
+	case hasPrefix(b, proxyV2Signature):
+		return parseProxyV2(b)
+	case hasPrefix(b, proxyV1Prefix):
+		return parseProxyV1(b)
+	}
+	return nil, 0, errNoProxyHeader
+}
+
+// hasPrefix reports whether b starts with prefix, or could once more data arrives.
+func hasPrefix(b, prefix []byte) bool {
+	if len(b) < len(prefix) {
+		return bytes.HasPrefix(prefix, b)
+	}
+	return bytes.HasPrefix(b, prefix)
+}
+
+func parseProxyV1(b []byte) (*ProxyHeader, int, error) {
+	end := bytes.Index(b, []byte("\r\n"))
+	if end == -1 {
+		if len(b) >= proxyV1MaxLen {
+			return nil, 0, ErrProxyHeader
+		}
+		return nil, 0, nil
+	}
+	if end+2 > proxyV1MaxLen {
+		return nil, 0, ErrProxyHeader
+	}
+
+	fields := strings.Split(string(b[:end]), " ")
+	h := &ProxyHeader{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		h.Local = true
+		return h, end + 2, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, 0, ErrProxyHeader
+	}
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	sport, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dport, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if src == nil || dst == nil || err1 != nil || err2 != nil {
+		return nil, 0, ErrProxyHeader
+	}
+	if (src.To4() != nil) != (fields[1] == "TCP4") || (dst.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, 0, ErrProxyHeader
+	}
+	h.SourceAddr = &net.TCPAddr{IP: src, Port: int(sport)}
+	h.DestAddr = &net.TCPAddr{IP: dst, Port: int(dport)}
+	return h, end + 2, nil
+}
+
+func parseProxyV2(b []byte) (*ProxyHeader, int, error) {
+	if len(b) < proxyV2HeaderLen {
+		return nil, 0, nil
+	}
+	verCmd, fam := b[12], b[13]
+	if verCmd>>4 != 2 {
+		return nil, 0, ErrProxyHeader
+	}
+	n := proxyV2HeaderLen + int(binary.BigEndian.Uint16(b[14:16]))
+	if len(b) < n {
+		return nil, 0, nil
+	}
+	payload := b[proxyV2HeaderLen:n]
+
+	h := &ProxyHeader{Version: 2}
+	switch verCmd & 0xF {
+	case 0x0:
+		h.Local = true
+	case 0x1:
+	default:
+		return nil, 0, ErrProxyHeader
+	}
+
+	// the transport is UNSPEC exactly when the family is, and a stream or datagram otherwise
+	if fam&0xF > 0x2 || (fam>>4 == 0x0) != (fam&0xF == 0x0) {
+		if !h.Local {
+			return nil, 0, ErrProxyHeader
+		}
+	}
+	var addrLen int
+	switch fam >> 4 {
+	case 0x0:
+	case 0x1:
+		addrLen = 12
+	case 0x2:
+		addrLen = 36
+	case 0x3:
+		addrLen = 216
+	default:
+		return nil, 0, ErrProxyHeader
+	}
+	if len(payload) < addrLen {
+		return nil, 0, ErrProxyHeader
+	}
+	if !h.Local && addrLen > 0 {
+		h.SourceAddr, h.DestAddr = proxyV2Addrs(fam, payload[:addrLen])
+	}
+
+	tlvs := payload[addrLen:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, 0, ErrProxyHeader
+		}
+		l := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+l {
+			return nil, 0, ErrProxyHeader
+		}
+		value := append([]byte(nil), tlvs[3:3+l]...)
+		h.TLVs = append(h.TLVs, ProxyTLV{Type: tlvs[0], Value: value})
+		tlvs = tlvs[3+l:]
+	}
+	return h, n, nil
+}
+
+func proxyV2Addrs(fam byte, b []byte) (net.Addr, net.Addr) {
+	dgram := fam&0xF == 0x2
+	ipAddr := func(ip net.IP, port []byte) net.Addr {
+		ip = append(net.IP(nil), ip...)
+		p := int(binary.BigEndian.Uint16(port))
+		if dgram {
+			return &net.UDPAddr{IP: ip, Port: p}
+		}
+		return &net.TCPAddr{IP: ip, Port: p}
+	}
+	switch fam >> 4 {
+	case 0x1:
+		return ipAddr(b[0:4], b[8:10]), ipAddr(b[4:8], b[10:12])
+	case 0x2:
+		return ipAddr(b[0:16], b[32:34]), ipAddr(b[16:32], b[34:36])
+	}
+	network := "unix"
+	if dgram {
+		network = "unixgram"
+	}
+	unixName := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			return string(b[:i])
+		}
+		return string(b)
+	}
+	return &net.UnixAddr{Name: unixName(b[:108]), Net: network}, &net.UnixAddr{Name: unixName(b[108:216]), Net: network}
+}
+
+// streamAddrs returns the addresses that replace the connection's, ok is false when
+// the header has none for a TCP stream.
+func (h *ProxyHeader) streamAddrs() (src, dst net.Addr, ok bool) {
+	if h.Local {
+		return nil, nil, false
+	}
+	_, srcOK := h.SourceAddr.(*net.TCPAddr)
+	_, dstOK := h.DestAddr.(*net.TCPAddr)
+	return h.SourceAddr, h.DestAddr, srcOK && dstOK
+}
+
+// proxyTrusted reports whether addr may send PROXY headers, addresses without an IP
+// belong to unix sockets and are trusted.
+func proxyTrusted(networks []*net.IPNet, addr net.Addr) bool {
+	ip := addrIP(addr)
+	return ip == nil || containsIP(networks, ip)
+}
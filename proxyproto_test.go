@@ -0,0 +1,204 @@
+package evnio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+// proxyV2 builds a v2 header with the given version/command and family bytes.
+func proxyV2(verCmd, fam byte, payload []byte) []byte {
+	b := append([]byte(nil), proxyV2Signature...)
+	b = append(b, verCmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(b[14:], uint16(len(payload)))
+	return append(b, payload...)
+}
+
+func proxyTLV(t byte, value []byte) []byte {
+	b := []byte{t, 0, 0}
+	binary.BigEndian.PutUint16(b[1:], uint16(len(value)))
+	return append(b, value...)
+}
+
+func proxyV2TCP4() []byte {
+	b := append(net.IPv4(10, 0, 0, 1).To4(), net.IPv4(10, 0, 0, 2).To4()...)
+	return append(b, 0x30, 0x39, 0x01, 0xbb)
+}
+
+func proxyV2TCP6() []byte {
+	b := append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...)
+	return append(b, 0x30, 0x39, 0x01, 0xbb)
+}
+
+func proxyV2Unix() []byte {
+	b := make([]byte, 216)
+	copy(b, "/tmp/src.sock")
+	copy(b[108:], "/tmp/dst.sock")
+	return b
+}
+
+func addrString(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	return a.Network() + " " + a.String()
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	longV6 := "PROXY TCP6 ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff 65535 65535\r\n"
+	tooLong := "PROXY TCP4 1.2.3.4 5.6.7.8 1 2" + strings.Repeat(" ", proxyV1MaxLen-31) + "\r\n"
+
+	tests := []struct {
+		name  string
+		in    []byte
+		n     int
+		err   error
+		local bool
+		src   string
+		dst   string
+		tlvs  int
+	}{
+		{name: "v1 tcp4", in: []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nGET"), n: 47,
+			src: "tcp 192.168.0.1:56324", dst: "tcp 192.168.0.11:443"},
+		{name: "v1 tcp6", in: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n"), n: 40,
+			src: "tcp [2001:db8::1]:1", dst: "tcp [2001:db8::2]:2"},
+		{name: "v1 longest", in: []byte(longV6), n: len(longV6),
+			src: "tcp [ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535", dst: "tcp [ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535"},
+		{name: "v1 unknown", in: []byte("PROXY UNKNOWN\r\n"), n: 15, local: true},
+		{name: "v1 unknown with addresses", in: []byte("PROXY UNKNOWN ::1 ::1 1 2\r\n"), n: 27, local: true},
+		{name: "v1 truncated", in: []byte("PROXY TCP4 192.168.0.1 192.16")},
+		{name: "v1 prefix", in: []byte("PRO")},
+		{name: "v1 over 107 bytes", in: []byte(tooLong), err: ErrProxyHeader},
+		{name: "v1 over 107 bytes without CRLF", in: []byte("PROXY " + strings.Repeat("x", proxyV1MaxLen)), err: ErrProxyHeader},
+		{name: "v1 family mismatch", in: []byte("PROXY TCP4 2001:db8::1 10.0.0.1 1 2\r\n"), err: ErrProxyHeader},
+		{name: "v1 bad port", in: []byte("PROXY TCP4 10.0.0.1 10.0.0.2 99999 2\r\n"), err: ErrProxyHeader},
+		{name: "v1 bad protocol", in: []byte("PROXY UDP4 10.0.0.1 10.0.0.2 1 2\r\n"), err: ErrProxyHeader},
+		{name: "v1 missing field", in: []byte("PROXY TCP4 10.0.0.1 10.0.0.2 1\r\n"), err: ErrProxyHeader},
+		{name: "no header", in: []byte("GET / HTTP/1.1\r\n"), err: errNoProxyHeader},
+
+		{name: "v2 tcp4 with tlv", in: proxyV2(0x21, 0x11, append(proxyV2TCP4(), proxyTLV(ProxyTLVALPN, []byte("h2"))...)), n: 16 + 12 + 5,
+			src: "tcp 10.0.0.1:12345", dst: "tcp 10.0.0.2:443", tlvs: 1},
+		{name: "v2 tcp6", in: proxyV2(0x21, 0x21, proxyV2TCP6()), n: 16 + 36,
+			src: "tcp [2001:db8::1]:12345", dst: "tcp [2001:db8::2]:443"},
+		{name: "v2 udp4", in: proxyV2(0x21, 0x12, proxyV2TCP4()), n: 16 + 12,
+			src: "udp 10.0.0.1:12345", dst: "udp 10.0.0.2:443"},
+		{name: "v2 unix", in: proxyV2(0x21, 0x31, proxyV2Unix()), n: 16 + 216,
+			src: "unix /tmp/src.sock", dst: "unix /tmp/dst.sock"},
+		{name: "v2 local", in: proxyV2(0x20, 0x00, nil), n: 16, local: true},
+		{name: "v2 local with addresses", in: proxyV2(0x20, 0x11, proxyV2TCP4()), n: 16 + 12, local: true},
+		{name: "v2 truncated header", in: proxyV2(0x21, 0x11, proxyV2TCP4())[:14]},
+		{name: "v2 truncated payload", in: proxyV2(0x21, 0x11, proxyV2TCP4())[:20]},
+		{name: "v2 bad version", in: proxyV2(0x11, 0x11, proxyV2TCP4()), err: ErrProxyHeader},
+		{name: "v2 bad command", in: proxyV2(0x22, 0x11, proxyV2TCP4()), err: ErrProxyHeader},
+		{name: "v2 bad family", in: proxyV2(0x21, 0x41, proxyV2TCP4()), err: ErrProxyHeader},
+		{name: "v2 unspec family with stream", in: proxyV2(0x21, 0x01, nil), err: ErrProxyHeader},
+		{name: "v2 inet family with unspec transport", in: proxyV2(0x21, 0x10, proxyV2TCP4()), err: ErrProxyHeader},
+		{name: "v2 bad transport", in: proxyV2(0x21, 0x13, proxyV2TCP4()), err: ErrProxyHeader},
+		{name: "v2 local with bad transport", in: proxyV2(0x20, 0x13, proxyV2TCP4()), n: 16 + 12, local: true},
+		{name: "v2 family length mismatch", in: proxyV2(0x21, 0x21, proxyV2TCP4()), err: ErrProxyHeader},
+		{name: "v2 tlv overrun", in: proxyV2(0x21, 0x11, append(proxyV2TCP4(), 0x01, 0x00, 0x0a, 'h', '2')), err: ErrProxyHeader},
+		{name: "v2 tlv truncated", in: proxyV2(0x21, 0x11, append(proxyV2TCP4(), 0x01, 0x00)), err: ErrProxyHeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, n, err := parseProxyHeader(tt.in)
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if n != tt.n {
+				t.Fatalf("n = %d, want %d", n, tt.n)
+			}
+			if tt.err != nil || tt.n == 0 {
+				if h != nil {
+					t.Fatalf("header = %+v, want nil", h)
+				}
+				return
+			}
+			if h.Local != tt.local {
+				t.Errorf("Local = %v, want %v", h.Local, tt.local)
+			}
+			if got := addrString(h.SourceAddr); got != tt.src {
+				t.Errorf("SourceAddr = %q, want %q", got, tt.src)
+			}
+			if got := addrString(h.DestAddr); got != tt.dst {
+				t.Errorf("DestAddr = %q, want %q", got, tt.dst)
+			}
+			if len(h.TLVs) != tt.tlvs {
+				t.Errorf("TLVs = %d, want %d", len(h.TLVs), tt.tlvs)
+			}
+		})
+	}
+}
+
+func TestParseProxyHeaderSplit(t *testing.T) {
+	headers := [][]byte{
+		[]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"),
+		proxyV2(0x21, 0x11, append(proxyV2TCP4(), proxyTLV(ProxyTLVAuthority, []byte("example.com"))...)),
+	}
+	for _, full := range headers {
+		for i := 0; i < len(full); i++ {
+			if h, n, err := parseProxyHeader(full[:i]); h != nil || n != 0 || err != nil {
+				t.Fatalf("%q: prefix of %d bytes = %v, %d, %v, want more data", full, i, h, n, err)
+			}
+		}
+		h, n, err := parseProxyHeader(full)
+		if err != nil || h == nil || n != len(full) {
+			t.Fatalf("%q: = %v, %d, %v", full, h, n, err)
+		}
+		if v, ok := h.TLV(ProxyTLVAuthority); h.Version == 2 && (!ok || !bytes.Equal(v, []byte("example.com"))) {
+			t.Fatalf("authority TLV = %q, %v", v, ok)
+		}
+	}
+}
+
+func TestProxyHeaderStreamAddrs(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		ok   bool
+	}{
+		{name: "v1 tcp4", in: []byte("PROXY TCP4 10.0.0.1 10.0.0.2 1 2\r\n"), ok: true},
+		{name: "v1 unknown", in: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v2 tcp4", in: proxyV2(0x21, 0x11, proxyV2TCP4()), ok: true},
+		{name: "v2 tcp6", in: proxyV2(0x21, 0x21, proxyV2TCP6()), ok: true},
+		{name: "v2 udp4", in: proxyV2(0x21, 0x12, proxyV2TCP4())},
+		{name: "v2 unix", in: proxyV2(0x21, 0x31, proxyV2Unix())},
+		{name: "v2 local", in: proxyV2(0x20, 0x11, proxyV2TCP4())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _, err := parseProxyHeader(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, ok := h.streamAddrs(); ok != tt.ok {
+				t.Fatalf("streamAddrs ok = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestProxyTrusted(t *testing.T) {
+	_, lb, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{lb}
+	tests := []struct {
+		name     string
+		networks []*net.IPNet
+		addr     net.Addr
+		want     bool
+	}{
+		{name: "trusted", networks: trusted, addr: &net.TCPAddr{IP: net.IPv4(10, 1, 2, 3), Port: 1}, want: true},
+		{name: "untrusted", networks: trusted, addr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1}},
+		{name: "no networks", addr: &net.TCPAddr{IP: net.IPv4(10, 1, 2, 3), Port: 1}},
+		{name: "unix", addr: &net.UnixAddr{Name: "/tmp/lb.sock", Net: "unix"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := proxyTrusted(tt.networks, tt.addr); got != tt.want {
+				t.Fatalf("proxyTrusted = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package evnio
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket refilled with Rate tokens per second, holding at most
+// Burst tokens, Rate when Burst is zero. A zero Rate is unlimited.
+type RateLimit struct {
+	Rate  int
+	Burst int
+}
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns nil when l is unlimited.
+func newTokenBucket(l RateLimit) *tokenBucket {
+	if l.Rate <= 0 {
+		return nil
+	}
+	burst := l.Burst
+	if burst <= 0 {
+		burst = l.Rate
+	}
+	return &tokenBucket{
+		rate:   float64(l.Rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+func (b *tokenBucket) available(now time.Time) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return int(b.tokens)
+}
+
+// take removes n tokens, a shared bucket may go into debt when loops race for it.
+func (b *tokenBucket) take(n int, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens -= float64(n)
+}
+
+// wait returns how long until the bucket holds at least one token.
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+type rateLimiter []*tokenBucket
+
+func newRateLimiter(limits ...*tokenBucket) rateLimiter {
+	var rl rateLimiter
+	for _, b := range limits {
+		if b != nil {
+			rl = append(rl, b)
+		}
+	}
+	return rl
+}
+
+// available returns the tokens every bucket can give.
+func (rl rateLimiter) available(now time.Time) int {
+	n := math.MaxInt32
+	for _, b := range rl {
+		if a := b.available(now); a < n {
+			n = a
+		}
+	}
+	return n
+}
+
+func (rl rateLimiter) take(n int, now time.Time) {
+	for _, b := range rl {
+		b.take(n, now)
+	}
+}
+
+func (rl rateLimiter) wait(now time.Time) time.Duration {
+	var d time.Duration
+	for _, b := range rl {
+		if w := b.wait(now); w > d {
+			d = w
+		}
+	}
+	return d
+}
@@ -0,0 +1,125 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// lineProtocol frames newline terminated messages.
+type lineProtocol struct{}
+
+func (lineProtocol) UnPacket(c Connection, buffer *bytes.Buffer) []byte {
+	i := bytes.IndexByte(buffer.Bytes(), '\n')
+	if i < 0 {
+		return nil
+	}
+	return buffer.Next(i + 1)
+}
+
+func (lineProtocol) Packet(c Connection, data []byte) []byte {
+	return data
+}
+
+type countHandler struct {
+	nopHandler
+	bytes    int64
+	messages int64
+}
+
+func (h *countHandler) OnMessage(c Connection, data []byte) {
+	atomic.AddInt64(&h.bytes, int64(len(data)))
+	atomic.AddInt64(&h.messages, 1)
+}
+
+func TestRateLimitPausesAndResumesReading(t *testing.T) {
+	line := strings.Repeat("x", 99) + "\n"
+	tests := []struct {
+		name    string
+		opts    func(*Options) *Options
+		clients int
+		// lines each client sends
+		lines int
+		// count reads the unit the limit applies to
+		count func(h *countHandler) int64
+		burst int64
+		// total is what the clients send, in the limited unit
+		total int64
+		// rate of the limit, the rest of total can't arrive faster
+		rate int64
+	}{
+		{
+			name:    "connection bytes",
+			opts:    func(o *Options) *Options { return o.SetReadLimit(RateLimit{Rate: 4000, Burst: 1000}) },
+			clients: 1, lines: 20, burst: 1000, total: 2000, rate: 4000,
+			count: func(h *countHandler) int64 { return atomic.LoadInt64(&h.bytes) },
+		},
+		{
+			name:    "connection messages",
+			opts:    func(o *Options) *Options { return o.SetMessageLimit(RateLimit{Rate: 40, Burst: 5}) },
+			clients: 1, lines: 15, burst: 5, total: 15, rate: 40,
+			count: func(h *countHandler) int64 { return atomic.LoadInt64(&h.messages) },
+		},
+		{
+			name:    "server bytes",
+			opts:    func(o *Options) *Options { return o.SetServerReadLimit(RateLimit{Rate: 4000, Burst: 1000}) },
+			clients: 2, lines: 10, burst: 1000, total: 2000, rate: 4000,
+			count: func(h *countHandler) int64 { return atomic.LoadInt64(&h.bytes) },
+		},
+		{
+			name:    "server messages",
+			opts:    func(o *Options) *Options { return o.SetServerMessageLimit(RateLimit{Rate: 40, Burst: 5}) },
+			clients: 3, lines: 5, burst: 5, total: 15, rate: 40,
+			count: func(h *countHandler) int64 { return atomic.LoadInt64(&h.messages) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &countHandler{}
+			srv := NewServer(tt.opts(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(1).SetHandler(h).SetProtocol(lineProtocol{})))
+			go srv.Start()
+			defer srv.Shutdown(context.Background())
+			addr := listenAddr(t, srv).String()
+
+			var conns []net.Conn
+			for i := 0; i < tt.clients; i++ {
+				nc, err := net.Dial("tcp", addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer nc.Close()
+				conns = append(conns, nc)
+			}
+			waitFor(t, "connections", func() bool { return srv.Count() == tt.clients })
+			start := time.Now()
+			for _, nc := range conns {
+				if _, err := nc.Write([]byte(strings.Repeat(line, tt.lines))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// the burst is let through, then reading stops until the buckets refill
+			time.Sleep(50 * time.Millisecond)
+			if n := tt.count(h); n > tt.burst+tt.rate/10 {
+				t.Fatalf("%d received in the first 50ms with a burst of %d", n, tt.burst)
+			}
+			deadline := time.Now().Add(3 * time.Second)
+			for tt.count(h) < tt.total {
+				if time.Now().After(deadline) {
+					t.Fatalf("reading did not resume, %d of %d received", tt.count(h), tt.total)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			min := time.Duration(tt.total-tt.burst) * time.Second / time.Duration(tt.rate)
+			if d := time.Since(start); d < min*3/4 {
+				t.Fatalf("received %d in %v, faster than the limit allows", tt.total, d)
+			}
+		})
+	}
+}
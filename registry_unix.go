@@ -0,0 +1,99 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import "sync"
+
+// registryShard holds the connections of one loop, it is only written from that loop.
+type registryShard struct {
+	mu    sync.RWMutex
+	conns map[uint64]*conn
+}
+
+// registry tracks the live connections of a server in one shard per loop so a
+// broadcast costs a single Trigger per loop.
+type registry struct {
+	loops  []*EventLoop
+	shards map[*EventLoop]*registryShard
+}
+
+func newRegistry(loops []*EventLoop) *registry {
+	r := &registry{
+		loops:  loops,
+		shards: make(map[*EventLoop]*registryShard, len(loops)),
+	}
+	for _, loop := range loops {
+		r.shards[loop] = &registryShard{conns: make(map[uint64]*conn)}
+	}
+	return r
+}
+
+// add registers c from its loop goroutine and removes it once c is closed.
+func (r *registry) add(c *conn) {
+	shard := r.shards[c.evLoop]
+	shard.mu.Lock()
+	shard.conns[c.uniqID] = c
+	shard.mu.Unlock()
+
+	c.closeHooks = append(c.closeHooks, func() {
+		shard.mu.Lock()
+		delete(shard.conns, c.uniqID)
+		shard.mu.Unlock()
+	})
+}
+
+func (r *registry) conn(id uint64) (*conn, bool) {
+	for _, loop := range r.loops {
+		shard := r.shards[loop]
+		shard.mu.RLock()
+		c, ok := shard.conns[id]
+		shard.mu.RUnlock()
+		if ok {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func (r *registry) count() int {
+	n := 0
+	for _, loop := range r.loops {
+		shard := r.shards[loop]
+		shard.mu.RLock()
+		n += len(shard.conns)
+		shard.mu.RUnlock()
+	}
+	return n
+}
+
+// rangeConns calls fn for a snapshot of every shard, fn may block or close connections.
+func (r *registry) rangeConns(fn func(c *conn) bool) {
+	for _, loop := range r.loops {
+		shard := r.shards[loop]
+		shard.mu.RLock()
+		conns := make([]*conn, 0, len(shard.conns))
+		for _, c := range shard.conns {
+			conns = append(conns, c)
+		}
+		shard.mu.RUnlock()
+
+		for _, c := range conns {
+			if !fn(c) {
+				return
+			}
+		}
+	}
+}
+
+// forEachOnLoop runs fn on every loop goroutine for the connections it owns.
+func (r *registry) forEachOnLoop(fn func(c *conn)) {
+	for _, loop := range r.loops {
+		shard := r.shards[loop]
+		loop.Trigger(func() {
+			// the shard is only written from this goroutine, reading needs no lock
+			for _, c := range shard.conns {
+				fn(c)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// dialClients connects n clients to srv and returns them keyed by the UniqID of their
+// server side connection.
+func dialClients(t *testing.T, srv Server, n int) map[uint64]net.Conn {
+	t.Helper()
+	addr := listenAddr(t, srv).String()
+	byAddr := make(map[string]net.Conn, n)
+	for i := 0; i < n; i++ {
+		nc, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { nc.Close() })
+		byAddr[nc.LocalAddr().String()] = nc
+	}
+	waitFor(t, "connections", func() bool { return srv.Count() == n })
+
+	clients := make(map[uint64]net.Conn, n)
+	srv.Range(func(c Connection) bool {
+		clients[c.UniqID()] = byAddr[c.RemoteAddr().String()]
+		return true
+	})
+	return clients
+}
+
+// expectRead checks that nc receives want, or nothing for a nil want.
+func expectRead(t *testing.T, nc net.Conn, want []byte) {
+	t.Helper()
+	if want == nil {
+		_ = nc.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if n, err := nc.Read(make([]byte, 16)); n > 0 || err == nil {
+			t.Fatalf("read %d bytes, %v, want nothing", n, err)
+		}
+		return
+	}
+	_ = nc.SetReadDeadline(time.Now().Add(time.Second))
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(nc, got); err != nil || string(got) != string(want) {
+		t.Fatalf("read %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestRegistryBroadcast(t *testing.T) {
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(2).SetHandler(nopHandler{}))
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+	clients := dialClients(t, srv, 4)
+
+	srv.Broadcast([]byte("all"), nil)
+	for _, nc := range clients {
+		expectRead(t, nc, []byte("all"))
+	}
+
+	var chosen uint64
+	for id := range clients {
+		chosen = id
+		break
+	}
+	srv.Broadcast([]byte("one"), func(c Connection) bool { return c.UniqID() == chosen })
+	for id, nc := range clients {
+		if id == chosen {
+			expectRead(t, nc, []byte("one"))
+		} else {
+			expectRead(t, nc, nil)
+		}
+	}
+}
+
+func TestRegistryConnAfterClose(t *testing.T) {
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(2).SetHandler(nopHandler{}))
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+	clients := dialClients(t, srv, 2)
+
+	for id := range clients {
+		c, ok := srv.Conn(id)
+		if !ok || c.UniqID() != id {
+			t.Fatalf("Conn(%d) = %v, %v", id, c, ok)
+		}
+		_ = c.Close()
+		waitFor(t, "close", func() bool { return srv.Count() == 1 })
+		if c, ok := srv.Conn(id); ok {
+			t.Fatalf("Conn(%d) = %v after close", id, c)
+		}
+		break
+	}
+	if _, ok := srv.Conn(0); ok {
+		t.Fatal("Conn(0) found a connection")
+	}
+}
+
+func TestRegistryRangeStops(t *testing.T) {
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(2).SetHandler(nopHandler{}))
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+	dialClients(t, srv, 4)
+
+	for stopAt := 1; stopAt <= 4; stopAt++ {
+		calls := 0
+		srv.Range(func(Connection) bool {
+			calls++
+			return calls < stopAt
+		})
+		if calls != stopAt {
+			t.Fatalf("Range called fn %d times, want %d", calls, stopAt)
+		}
+	}
+}
@@ -3,6 +3,8 @@
 package evnio
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"net"
 	"sync"
@@ -14,18 +16,20 @@ import (
 
 type server struct {
 	mu         sync.Mutex
+	opts       *Options
 	addr       string
-	protocol   Protocol
-	handler    ConnectionHandler
 	ln         *net.TCPListener
+	admission  *admission
+	groups     groups
+	conns      sync.Map
 	inShutdown util.AtomicBool
 }
 
 func NewServer(opt *Options) Server {
 	srv := &server{
-		addr:     opt.Addr,
-		protocol: opt.Protocol,
-		handler:  opt.Handler,
+		opts:      opt,
+		addr:      opt.Addr,
+		admission: newAdmission(opt),
 	}
 
 	return srv
@@ -50,12 +54,56 @@ func (srv *server) Start() error {
 	return srv.serve()
 }
 
-func (srv *server) Shutdown() error {
+func (srv *server) Shutdown(ctx context.Context) error {
 	srv.inShutdown.Set()
 
 	return srv.ln.Close()
 }
 
+func (srv *server) Stats() Stats {
+	return Stats{Rejected: srv.admission.Rejected()}
+}
+
+func (srv *server) EventLoops() []*EventLoop {
+	return nil
+}
+
+func (srv *server) Conn(id uint64) (Connection, bool) {
+	c, ok := srv.conns.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return c.(*conn), true
+}
+
+func (srv *server) Range(fn func(c Connection) bool) {
+	srv.conns.Range(func(key, value interface{}) bool {
+		return fn(value.(*conn))
+	})
+}
+
+func (srv *server) Count() int {
+	n := 0
+	srv.conns.Range(func(key, value interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func (srv *server) Group(name string) Group {
+	return srv.groups.get(name)
+}
+
+func (srv *server) Broadcast(data []byte, filter func(c Connection) bool) {
+	srv.Range(func(c Connection) bool {
+		if filter == nil || filter(c) {
+			_ = c.Send(data, ActionNone)
+		}
+		return true
+	})
+}
+
 func (srv *server) serve() error {
 	for {
 		rw, err := srv.ln.AcceptTCP()
@@ -74,5 +122,23 @@ func (srv *server) serve() error {
 }
 
 func (srv *server) newConnection(rw net.Conn) {
-	newConnection(rw, srv.protocol, srv.handler)
+	raddr := rw.RemoteAddr()
+	if err := srv.admission.admit(raddr); err != nil {
+		if h, ok := srv.opts.Handler.(RejectHandler); ok {
+			if b := h.OnReject(raddr, err); len(b) > 0 {
+				_, _ = rw.Write(b)
+			}
+		}
+		_ = rw.Close()
+		return
+	}
+	if srv.opts.TLSConfig != nil {
+		rw = tls.Server(rw, srv.opts.TLSConfig)
+	}
+	c := newConnection(rw, srv.opts)
+	srv.conns.Store(c.uniqID, c)
+	c.closeHooks = append(c.closeHooks, func() {
+		srv.admission.release(raddr)
+		srv.conns.Delete(c.uniqID)
+	})
 }
@@ -3,116 +3,457 @@
 package evnio
 
 import (
+	"context"
+	"errors"
+	"net"
+	"os"
 	"runtime"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/dreamans/evnio/util"
 
 	"github.com/dreamans/evnio/evlog"
 )
 
+const shutdownPollInterval = 50 * time.Millisecond
+
+var errReusePortNetwork = errors.New("evnio: ReusePort requires a tcp or udp address")
+
 type server struct {
-	addr          string
-	protocol      Protocol
-	handler       ConnectionHandler
-	numLoops      int
-	ln            *Listener
-	evLoop        *EventLoop
-	workEvLoops   []*EventLoop
-	nextLoopIndex int
-	inShutdown    util.AtomicBool
+	opts       *Options
+	addr       string
+	numLoops   int
+	balancer   LoadBalancer
+	groups     groups
+	admission  *admission
+	readLimit  *tokenBucket
+	msgLimit   *tokenBucket
+	handshakes *handshakeLimit
+	inShutdown util.AtomicBool
+
+	// mu guards the loops, listeners and registry set up by Start and EventLoops.
+	mu          sync.Mutex
+	listeners   []*Listener
+	pconns      []*packetConn
+	evLoop      *EventLoop
+	workEvLoops []*EventLoop
+	registry    *registry
 }
 
 func NewServer(opt *Options) Server {
-	return &server{
-		addr:     opt.Addr,
-		protocol: opt.Protocol,
-		handler:  opt.Handler,
-		numLoops: opt.NumLoops,
+	srv := &server{
+		opts:       opt,
+		addr:       opt.Addr,
+		numLoops:   opt.NumLoops,
+		balancer:   opt.LoadBalancer,
+		admission:  newAdmission(opt),
+		readLimit:  newTokenBucket(opt.ServerReadLimit),
+		msgLimit:   newTokenBucket(opt.ServerMessageLimit),
+		handshakes: newHandshakeLimit(opt.MaxTLSHandshakes),
+	}
+	if srv.balancer == nil {
+		srv.balancer = NewRoundRobinBalancer()
 	}
+	return srv
 }
 
 func (srv *server) Start() error {
 	if srv.inShutdown.IsSet() {
 		return ErrServerClosed
 	}
-	if err := srv.initEventLoop(); err != nil {
+	if _, err := srv.eventLoops(); err != nil {
+		return err
+	}
+	evLoop, err := newEventLoop()
+	if err != nil {
 		return err
 	}
-	if err := srv.initListener(srv.addr); err != nil {
+	if network, _ := util.ParseListenerAddr(srv.addr); strings.HasPrefix(network, "udp") {
+		err = srv.initPacketConn(srv.addr, evLoop)
+	} else {
+		err = srv.initListener(srv.addr, evLoop)
+	}
+	if err != nil {
+		srv.abortStart(evLoop)
 		return err
 	}
-	for i := 0; i < len(srv.workEvLoops); i++ {
-		go func(i int) {
-			srv.workEvLoops[i].Wait()
-		}(i)
+
+	srv.mu.Lock()
+	if srv.inShutdown.IsSet() {
+		srv.mu.Unlock()
+		srv.abortStart(evLoop)
+		return ErrServerClosed
 	}
-	srv.evLoop.Wait()
+	srv.evLoop = evLoop
+	srv.mu.Unlock()
+	evLoop.Wait()
 
 	return nil
 }
 
-func (srv *server) Shutdown() error {
+// abortStart closes the listeners and packet conns of a Start that failed or lost the race
+// with Shutdown, and evLoop with them.
+func (srv *server) abortStart(evLoop *EventLoop) {
+	srv.mu.Lock()
+	listeners, pconns := srv.listeners, srv.pconns
+	srv.listeners, srv.pconns = nil, nil
+	srv.mu.Unlock()
+
+	// the closes are triggered on the listeners' loops, evLoop runs until Stop has done them
+	go evLoop.Wait()
+	for _, l := range listeners {
+		_ = l.Close()
+		// a loop Shutdown already stopped won't run the close, the socket file must go anyway
+		if ua, ok := l.ln.Addr().(*net.UnixAddr); ok && !strings.HasPrefix(ua.Name, "@") {
+			_ = os.Remove(ua.Name)
+		}
+	}
+	for _, pc := range pconns {
+		_ = pc.Close()
+	}
+	_ = evLoop.Stop()
+}
+
+func (srv *server) Shutdown(ctx context.Context) error {
 	if srv.inShutdown.IsSet() {
 		return ErrServerClosed
 	}
+	srv.mu.Lock()
 	srv.inShutdown.Set()
+	evLoop, workEvLoops := srv.evLoop, srv.workEvLoops
+	listeners, pconns := srv.listeners, srv.pconns
+	srv.mu.Unlock()
 
-	for _, loop := range srv.workEvLoops {
-		_ = loop.Stop()
+	if evLoop == nil {
+		// never started, the worker loops may still run for EventLoops callers
+		for _, loop := range workEvLoops {
+			_ = loop.Stop()
+		}
+		return nil
+	}
+	for _, l := range listeners {
+		_ = l.Close()
+	}
+	for _, pc := range pconns {
+		_ = pc.Close()
+	}
+	for _, loop := range workEvLoops {
+		srv.drainEventLoop(loop)
 	}
-	_ = srv.evLoop.Stop()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for numConnections(workEvLoops) > 0 {
+		select {
+		case <-ctx.Done():
+			stopEventLoops(evLoop, workEvLoops)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	stopEventLoops(evLoop, workEvLoops)
 
 	return nil
 }
 
-func (srv *server) initEventLoop() error {
-	evLoop, err := newEventLoop()
+func (srv *server) Stats() Stats {
+	srv.mu.Lock()
+	listeners := srv.listeners
+	srv.mu.Unlock()
+
+	var stats Stats
+	for _, l := range listeners {
+		stats.add(l.Stats())
+	}
+	stats.Rejected = srv.admission.Rejected()
+	return stats
+}
+
+func (srv *server) EventLoops() []*EventLoop {
+	loops, err := srv.eventLoops()
 	if err != nil {
-		return err
+		return nil
+	}
+	return loops
+}
+
+// loadRegistry returns nil until the worker loops exist.
+func (srv *server) loadRegistry() *registry {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.registry
+}
+
+func (srv *server) Conn(id uint64) (Connection, bool) {
+	r := srv.loadRegistry()
+	if r == nil {
+		return nil, false
+	}
+	c, ok := r.conn(id)
+	if !ok {
+		return nil, false
+	}
+	return c, true
+}
+
+func (srv *server) Range(fn func(c Connection) bool) {
+	r := srv.loadRegistry()
+	if r == nil {
+		return
+	}
+	r.rangeConns(func(c *conn) bool {
+		return fn(c)
+	})
+}
+
+func (srv *server) Count() int {
+	r := srv.loadRegistry()
+	if r == nil {
+		return 0
+	}
+	return r.count()
+}
+
+func (srv *server) Group(name string) Group {
+	return srv.groups.get(name)
+}
+
+func (srv *server) Broadcast(data []byte, filter func(c Connection) bool) {
+	r := srv.loadRegistry()
+	if r == nil || len(data) == 0 {
+		return
+	}
+	// one copy is shared by every connection's write queue
+	data = append([]byte(nil), data...)
+	r.forEachOnLoop(func(c *conn) {
+		if filter != nil && !filter(c) {
+			return
+		}
+		if err := c.sendOnLoop(data, ActionNone); err != nil && err != ErrConnectionClosed {
+			evlog.Debugf("[Broadcast]: conn %d, %s", c.uniqID, err.Error())
+		}
+	})
+}
+
+func (srv *server) drainEventLoop(loop *EventLoop) {
+	loop.Trigger(func() {
+		loop.handlers.Range(func(key, value interface{}) bool {
+			if c, ok := value.(*conn); ok {
+				c.shutdown()
+			}
+			return true
+		})
+	})
+}
+
+func numConnections(loops []*EventLoop) int {
+	n := 0
+	for _, loop := range loops {
+		n += loop.NumConnections()
+	}
+	return n
+}
+
+func stopEventLoops(evLoop *EventLoop, workEvLoops []*EventLoop) {
+	for _, loop := range workEvLoops {
+		_ = loop.Stop()
+	}
+	_ = evLoop.Stop()
+}
+
+// eventLoops creates and runs the worker loops on first use, so they can be
+// shared before Start.
+func (srv *server) eventLoops() ([]*EventLoop, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.workEvLoops != nil {
+		return srv.workEvLoops, nil
+	}
+	if srv.inShutdown.IsSet() {
+		return nil, ErrServerClosed
 	}
-	srv.evLoop = evLoop
 
 	if srv.numLoops == 0 {
 		srv.numLoops = runtime.NumCPU()
 	}
-
-	workEvLoops := make([]*EventLoop, srv.numLoops)
+	loops := make([]*EventLoop, srv.numLoops)
 	for i := 0; i < srv.numLoops; i++ {
 		loop, err := newEventLoop()
 		if err != nil {
-			return err
+			for _, l := range loops[:i] {
+				go l.Wait()
+				_ = l.Stop()
+			}
+			return nil, err
 		}
-		workEvLoops[i] = loop
+		loops[i] = loop
+	}
+	for _, loop := range loops {
+		go loop.Wait()
 	}
+	srv.workEvLoops = loops
+	srv.registry = newRegistry(loops)
 
-	srv.workEvLoops = workEvLoops
+	return loops, nil
+}
 
+func (srv *server) initListener(addr string, evLoop *EventLoop) error {
+	if !srv.opts.ReusePort {
+		_, err := srv.addListener(addr, evLoop)
+		return err
+	}
+	if network, _ := util.ParseListenerAddr(addr); !strings.HasPrefix(network, "tcp") {
+		return errReusePortNetwork
+	}
+	for _, loop := range srv.workEvLoops {
+		l, err := srv.addListener(addr, loop)
+		if err != nil {
+			return err
+		}
+		// a zero port is resolved by the first bind, the others must share it
+		addr = l.ln.Addr().Network() + "://" + l.ln.Addr().String()
+	}
 	return nil
 }
 
-func (srv *server) initListener(addr string) error {
-	l, err := NewListener(addr, srv.evLoop, srv.newConnHandler)
+func (srv *server) addListener(addr string, loop *EventLoop) (*Listener, error) {
+	var l *Listener
+	l, err := newListener(addr, loop, func(ncfd int, sa syscall.Sockaddr) {
+		srv.newConnHandler(l, ncfd, sa)
+	}, srv.opts.ReusePort, srv.opts.UnixSocketMode)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	srv.ln = l
-	if err := srv.evLoop.AddFdHandler(srv.ln.Fd(), srv.ln); err != nil {
+	l.SetAcceptBatch(srv.opts.AcceptBatch)
+	srv.mu.Lock()
+	srv.listeners = append(srv.listeners, l)
+	srv.mu.Unlock()
+	if err := loop.AddFdHandler(l.Fd(), l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (srv *server) initPacketConn(addr string, evLoop *EventLoop) error {
+	if srv.opts.PacketHandler == nil {
+		return errors.New("evnio: udp server requires a PacketHandler")
+	}
+	if !srv.opts.ReusePort {
+		_, err := srv.addPacketConn(addr, evLoop)
 		return err
 	}
+	for _, loop := range srv.workEvLoops {
+		pc, err := srv.addPacketConn(addr, loop)
+		if err != nil {
+			return err
+		}
+		addr = pc.LocalAddr().Network() + "://" + pc.LocalAddr().String()
+	}
 	return nil
 }
 
-func (srv *server) newConnHandler(ncfd int, sa syscall.Sockaddr) {
-	workLoop := srv.evLoopBalance()
-	c := newConnection(ncfd, workLoop, util.SockAddrToAddr(sa), srv.ln.ln.Addr(), srv.protocol, srv.handler)
-	if err := workLoop.AddFdHandler(ncfd, c); err != nil {
-		evlog.Error("[workLoop.AddFdHandler]: %s", err.Error())
+func (srv *server) addPacketConn(addr string, loop *EventLoop) (*packetConn, error) {
+	pc, err := newPacketConn(addr, loop, srv.opts.PacketHandler, srv.opts.ReusePort)
+	if err != nil {
+		return nil, err
+	}
+	srv.mu.Lock()
+	srv.pconns = append(srv.pconns, pc)
+	srv.mu.Unlock()
+	if err := loop.AddFdHandler(pc.Fd(), pc); err != nil {
+		return nil, err
 	}
+	return pc, nil
 }
 
-func (srv *server) evLoopBalance() *EventLoop {
-	loop := srv.workEvLoops[srv.nextLoopIndex]
-	srv.nextLoopIndex = (srv.nextLoopIndex + 1) % len(srv.workEvLoops)
-	return loop
+func (srv *server) newConnHandler(l *Listener, ncfd int, sa syscall.Sockaddr) {
+	if srv.inShutdown.IsSet() {
+		_ = syscall.Close(ncfd)
+		return
+	}
+	raddr := util.SockAddrToAddr(sa)
+	proxied := srv.opts.ProxyProtocol != ProxyProtocolOff
+	// behind a proxy only the trust check and MaxConnections apply until the header
+	// carries the client address
+	admit := srv.admission.admit
+	if proxied {
+		admit = srv.admission.admitProxied
+	}
+	if err := admit(raddr); err != nil {
+		srv.reject(ncfd, raddr, err)
+		_ = syscall.Close(ncfd)
+		return
+	}
+	workLoop := l.evLoop
+	if !srv.opts.ReusePort {
+		workLoop = selectEventLoop(srv.balancer, srv.workEvLoops, raddr)
+	}
+	// the slot is taken now so a burst of accepts doesn't pile onto one loop,
+	// the connection gives it back when it closes
+	workLoop.reserveConn()
+	laddr := l.ln.Addr()
+	// admitted is the address the admission slot is counted under
+	admitted := raddr
+	if proxied {
+		admitted = nil
+	}
+	// the connection is opened on its own loop so data sent from OnOpen
+	// can't be flushed before the fd is registered
+	workLoop.Trigger(func() {
+		if srv.inShutdown.IsSet() {
+			srv.admission.release(admitted)
+			workLoop.releaseConn()
+			_ = syscall.Close(ncfd)
+			return
+		}
+		c := newConnection(ncfd, workLoop, raddr, laddr, srv.opts)
+		c.closeHooks = append(c.closeHooks, func() {
+			srv.admission.release(admitted)
+		})
+		c.registry = srv.registry
+		c.readLimiter = append(c.readLimiter, newRateLimiter(srv.readLimit)...)
+		c.messageLimiter = append(c.messageLimiter, newRateLimiter(srv.msgLimit)...)
+		if err := workLoop.AddFdHandler(ncfd, c); err != nil {
+			evlog.Errorf("[workLoop.AddFdHandler]: %s", err.Error())
+			c.handleClose(ncfd, err)
+			return
+		}
+		if proxied {
+			c.expectProxyHeader(srv.opts.ProxyProtocol, srv.opts.ProxyHeaderTimeout, func() {
+				// RemoteAddr is the client's once the header has been read
+				addr := c.RemoteAddr()
+				if err := srv.admission.readmit(addr); err != nil {
+					srv.reject(ncfd, addr, err)
+					c.handleClose(ncfd, err)
+					return
+				}
+				admitted = addr
+				srv.startConnection(c)
+			})
+			return
+		}
+		srv.startConnection(c)
+	})
+}
+
+// reject answers a connection refused by admission control, the caller closes it.
+func (srv *server) reject(fd int, addr net.Addr, reason error) {
+	evlog.Debugf("[reject]: remote %s, %s", addr, reason.Error())
+	if h, ok := srv.opts.Handler.(RejectHandler); ok {
+		if b := h.OnReject(addr, reason); len(b) > 0 {
+			_, _ = syscall.Write(fd, b)
+		}
+	}
+}
+
+func (srv *server) startConnection(c *conn) {
+	if srv.opts.TLSConfig != nil {
+		c.startTLS(srv.opts.TLSConfig, false, srv.handshakes, srv.opts.TLSHandshakeTimeout)
+	} else {
+		c.open()
+	}
 }
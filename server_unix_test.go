@@ -0,0 +1,273 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type nopHandler struct{}
+
+func (nopHandler) OnOpen(c Connection)                 {}
+func (nopHandler) OnMessage(c Connection, data []byte) {}
+func (nopHandler) OnClose(c Connection)                {}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// listenAddr waits for the first listener srv binds, so tests can listen on port 0.
+func listenAddr(t *testing.T, srv Server) net.Addr {
+	t.Helper()
+	s := srv.(*server)
+	var addr net.Addr
+	waitFor(t, "listener", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.evLoop == nil {
+			return false
+		}
+		if len(s.listeners) > 0 {
+			addr = s.listeners[0].ln.Addr()
+		} else if len(s.pconns) > 0 {
+			addr = s.pconns[0].LocalAddr()
+		}
+		return addr != nil
+	})
+	return addr
+}
+
+func TestServerEventLoopsDuringStart(t *testing.T) {
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(2).SetHandler(nopHandler{}))
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if n := len(srv.EventLoops()); n != 2 {
+					t.Errorf("EventLoops() = %d loops, want 2", n)
+					return
+				}
+				srv.Count()
+				srv.Range(func(Connection) bool { return true })
+				srv.Stats()
+			}
+		}()
+	}
+
+	started := make(chan error, 1)
+	go func() {
+		started <- srv.Start()
+	}()
+
+	nc, err := net.Dial("tcp", listenAddr(t, srv).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	waitFor(t, "connection", func() bool { return srv.Count() == 1 })
+
+	close(stop)
+	wg.Wait()
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-started; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerShutdownBeforeStart(t *testing.T) {
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(1).SetHandler(nopHandler{}))
+	if len(srv.EventLoops()) != 1 {
+		t.Fatal("EventLoops() did not start the worker loops")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown blocked on a server that never started")
+	}
+	if err := srv.Start(); err != ErrServerClosed {
+		t.Fatalf("Start() = %v, want ErrServerClosed", err)
+	}
+}
+
+func TestAbortStartClosesListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evnio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "s.sock")
+
+	srv := NewServer(NewOptions().SetAddr("unix://" + path).SetNumLoops(1).SetHandler(nopHandler{})).(*server)
+	if _, err := srv.eventLoops(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+	evLoop, err := newEventLoop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.initListener(srv.addr, evLoop); err != nil {
+		t.Fatal(err)
+	}
+	l := srv.listeners[0]
+
+	srv.abortStart(evLoop)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file left behind: %v", err)
+	}
+	if !l.closed {
+		t.Fatal("listener left open")
+	}
+	if len(srv.listeners) != 0 {
+		t.Fatalf("%d listeners still tracked", len(srv.listeners))
+	}
+}
+
+func TestStartFailureClosesReusePortListeners(t *testing.T) {
+	// the second loop can't share a port bound without SO_REUSEPORT
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(2).SetReusePort(true).SetHandler(nopHandler{})).(*server)
+	loops, err := srv.eventLoops()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+	l, err := srv.addListener(srv.addr, loops[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a partial Start: the first listener is up when the next one fails
+	if _, err := srv.addListener("tcp://"+taken.Addr().String(), loops[1]); err == nil {
+		t.Fatal("bound a port already in use")
+	}
+	evLoop, err := newEventLoop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.abortStart(evLoop)
+
+	waitFor(t, "listener close", func() bool {
+		nc, err := net.Dial("tcp", l.ln.Addr().String())
+		if err == nil {
+			nc.Close()
+		}
+		return err != nil
+	})
+}
+
+type drainHandler struct {
+	nopHandler
+	opened chan Connection
+	data   []byte
+}
+
+func (h *drainHandler) OnOpen(c Connection) {
+	_ = c.Send(h.data, ActionNone)
+	h.opened <- c
+}
+
+func (h *drainHandler) OnShutdown(c Connection) {
+	_ = c.Send([]byte("bye"), ActionNone)
+}
+
+func TestShutdownDrainsWrites(t *testing.T) {
+	h := &drainHandler{opened: make(chan Connection, 1), data: bytes.Repeat([]byte("x"), 8<<20)}
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(1).SetHandler(h))
+	go srv.Start()
+	nc, err := net.Dial("tcp", listenAddr(t, srv).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	<-h.opened
+
+	// the peer hasn't read yet, Shutdown waits for the queue to flush
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- srv.Shutdown(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v with writes pending", err)
+	default:
+	}
+
+	_ = nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := ioutil.ReadAll(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(h.data, "bye"...); !bytes.Equal(got, want) {
+		t.Fatalf("read %d bytes ending %q, want %d ending in bye", len(got), got[len(got)-3:], len(want))
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+}
+
+func TestShutdownContextExpires(t *testing.T) {
+	h := &drainHandler{opened: make(chan Connection, 1), data: make([]byte, 64<<20)}
+	srv := NewServer(NewOptions().SetAddr("tcp://127.0.0.1:0").SetNumLoops(1).SetHandler(h))
+	go srv.Start()
+	nc, err := net.Dial("tcp", listenAddr(t, srv).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	<-h.opened
+
+	// a peer that never reads can't hold Shutdown past its context
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown() = %v, want context.DeadlineExceeded", err)
+	}
+	_ = nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.Copy(ioutil.Discard, nc); err != nil {
+		t.Fatalf("connection not closed: %v", err)
+	}
+}
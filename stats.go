@@ -0,0 +1,20 @@
+package evnio
+
+// Stats are counters collected since the server started.
+type Stats struct {
+	// Accepted is the number of connections accepted from the listen backlog.
+	Accepted uint64
+	// AcceptErrors counts failed accept calls, including FdExhausted.
+	AcceptErrors uint64
+	// FdExhausted counts accepts that failed with EMFILE or ENFILE.
+	FdExhausted uint64
+	// Rejected counts accepted connections closed by admission control.
+	Rejected uint64
+}
+
+func (s *Stats) add(o Stats) {
+	s.Accepted += o.Accepted
+	s.AcceptErrors += o.AcceptErrors
+	s.FdExhausted += o.FdExhausted
+	s.Rejected += o.Rejected
+}
@@ -0,0 +1,103 @@
+package evnio
+
+import (
+	"time"
+
+	"github.com/dreamans/evnio/util"
+)
+
+const (
+	timerWheelSize = 512
+	timerWheelTick = 10 * time.Millisecond
+)
+
+// Timer is a handle to a function scheduled on an EventLoop by AfterFunc or Every.
+type Timer struct {
+	fn       func()
+	interval time.Duration
+	rounds   int
+	stopped  util.AtomicBool
+}
+
+// Stop cancels the timer, it is safe to call from any goroutine.
+func (t *Timer) Stop() {
+	t.stopped.Set()
+}
+
+// timerWheel is a hashed timing wheel, it is only accessed from its loop goroutine.
+type timerWheel struct {
+	slots [][]*Timer
+	pos   int
+	count int
+	last  time.Time
+}
+
+func newTimerWheel() *timerWheel {
+	return &timerWheel{
+		slots: make([][]*Timer, timerWheelSize),
+	}
+}
+
+func (tw *timerWheel) add(t *Timer, when time.Time) {
+	if tw.count == 0 {
+		tw.last = time.Now()
+	}
+	ticks := int((when.Sub(tw.last) + timerWheelTick - 1) / timerWheelTick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	t.rounds = (ticks - 1) / len(tw.slots)
+	slot := (tw.pos + ticks) % len(tw.slots)
+	tw.slots[slot] = append(tw.slots[slot], t)
+	tw.count++
+}
+
+func (tw *timerWheel) timeout() time.Duration {
+	if tw.count == 0 {
+		return -1
+	}
+	for i := 1; i <= len(tw.slots); i++ {
+		if len(tw.slots[(tw.pos+i)%len(tw.slots)]) == 0 {
+			continue
+		}
+		d := time.Until(tw.last.Add(time.Duration(i) * timerWheelTick))
+		if d < 0 {
+			d = 0
+		}
+		return d
+	}
+	return 0
+}
+
+func (tw *timerWheel) advance(now time.Time) {
+	for tw.count > 0 && now.Sub(tw.last) >= timerWheelTick {
+		tw.last = tw.last.Add(timerWheelTick)
+		tw.pos = (tw.pos + 1) % len(tw.slots)
+		tw.expire(tw.pos)
+	}
+}
+
+func (tw *timerWheel) expire(slot int) {
+	timers := tw.slots[slot]
+	if len(timers) == 0 {
+		return
+	}
+	tw.slots[slot] = nil
+
+	for _, t := range timers {
+		if t.stopped.IsSet() {
+			tw.count--
+			continue
+		}
+		if t.rounds > 0 {
+			t.rounds--
+			tw.slots[slot] = append(tw.slots[slot], t)
+			continue
+		}
+		tw.count--
+		t.fn()
+		if t.interval > 0 && !t.stopped.IsSet() {
+			tw.add(t, time.Now().Add(t.interval))
+		}
+	}
+}
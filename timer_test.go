@@ -0,0 +1,138 @@
+package evnio
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ticks returns the wheel time n ticks after its current position.
+func ticks(tw *timerWheel, n int) time.Time {
+	return tw.last.Add(time.Duration(n) * timerWheelTick)
+}
+
+func TestTimerWheelMultiRound(t *testing.T) {
+	tw := newTimerWheel()
+	fired := 0
+	tw.add(&Timer{fn: func() { fired++ }}, time.Now().Add(3*timerWheelSize*timerWheelTick+5*timerWheelTick))
+	start := tw.last
+
+	for _, n := range []int{1, timerWheelSize, 2 * timerWheelSize, 3*timerWheelSize + 4} {
+		tw.advance(start.Add(time.Duration(n) * timerWheelTick))
+		if fired != 0 {
+			t.Fatalf("fired after %d ticks", n)
+		}
+	}
+	tw.advance(start.Add((3*timerWheelSize + 5) * timerWheelTick))
+	if fired != 1 {
+		t.Fatalf("fired = %d, want 1", fired)
+	}
+	if tw.count != 0 {
+		t.Fatalf("count = %d, want 0", tw.count)
+	}
+}
+
+func TestTimerWheelStop(t *testing.T) {
+	tw := newTimerWheel()
+	fired := 0
+	before := &Timer{fn: func() { fired++ }}
+	after := &Timer{fn: func() { fired++ }}
+	tw.add(before, time.Now().Add(5*timerWheelTick))
+	tw.add(after, ticks(tw, 5))
+
+	before.Stop()
+	tw.advance(ticks(tw, 5))
+	if fired != 1 {
+		t.Fatalf("fired = %d, want 1", fired)
+	}
+	if tw.count != 0 {
+		t.Fatalf("count = %d, want 0", tw.count)
+	}
+
+	after.Stop()
+	tw.add(&Timer{fn: func() { fired++ }}, time.Now().Add(timerWheelTick))
+	tw.advance(ticks(tw, 1))
+	if fired != 2 {
+		t.Fatalf("fired = %d, want 2", fired)
+	}
+}
+
+func TestTimerWheelAddFromCallback(t *testing.T) {
+	tw := newTimerWheel()
+	var order []string
+	var start time.Time
+	tw.add(&Timer{fn: func() {
+		order = append(order, "first")
+		// lands on the slot being expired, one full turn later
+		tw.add(&Timer{fn: func() { order = append(order, "turn") }}, start.Add((2+timerWheelSize)*timerWheelTick))
+		tw.add(&Timer{fn: func() { order = append(order, "next") }}, start.Add(3*timerWheelTick))
+	}}, time.Now().Add(2*timerWheelTick))
+	start = tw.last
+	// keeps the wheel from re-basing on the real clock once "first" has fired
+	tw.add(&Timer{fn: func() {}}, ticks(tw, 3*timerWheelSize))
+
+	tw.advance(start.Add(2 * timerWheelTick))
+	if len(order) != 1 {
+		t.Fatalf("order = %v", order)
+	}
+	tw.advance(start.Add(3 * timerWheelTick))
+	tw.advance(start.Add((1 + timerWheelSize) * timerWheelTick))
+	if len(order) != 2 || order[1] != "next" {
+		t.Fatalf("order = %v", order)
+	}
+	tw.advance(start.Add((2 + timerWheelSize) * timerWheelTick))
+	if len(order) != 3 || order[2] != "turn" {
+		t.Fatalf("order = %v", order)
+	}
+}
+
+func TestEventLoopEvery(t *testing.T) {
+	loop, err := newEventLoop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go loop.Wait()
+	defer loop.Stop()
+
+	var n int32
+	done := make(chan struct{})
+	timers := make(chan *Timer, 1)
+	timers <- loop.Every(20*time.Millisecond, func() {
+		if atomic.AddInt32(&n, 1) == 3 {
+			(<-timers).Stop()
+			close(done)
+		}
+	})
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Every fired %d times", atomic.LoadInt32(&n))
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := atomic.LoadInt32(&n); got != 3 {
+		t.Fatalf("Every fired %d times after Stop, want 3", got)
+	}
+
+	// a non-positive interval is clamped to one tick instead of firing once
+	for _, d := range []time.Duration{0, -time.Second} {
+		var n int32
+		tm := loop.Every(d, func() { atomic.AddInt32(&n, 1) })
+		deadline := time.Now().Add(2 * time.Second)
+		for atomic.LoadInt32(&n) < 3 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		tm.Stop()
+		if got := atomic.LoadInt32(&n); got < 3 {
+			t.Fatalf("Every(%v) fired %d times, want repeated", d, got)
+		}
+	}
+
+	fired := make(chan struct{}, 1)
+	loop.AfterFunc(time.Hour, func() { fired <- struct{}{} }).Stop()
+	loop.AfterFunc(10*time.Millisecond, func() { fired <- struct{}{} })
+	select {
+	case <-fired:
+	case <-time.After(2 * time.Second):
+		t.Fatal("AfterFunc did not fire")
+	}
+}
@@ -0,0 +1,331 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"iter"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/dreamans/evnio/util"
+)
+
+// maxSealSize bounds the plain data encrypted ahead of the socket.
+const maxSealSize = 256 << 10
+
+const defaultMaxTLSHandshakes = 1024
+
+type tlsWouldBlock struct{}
+
+func (tlsWouldBlock) Error() string   { return "evnio: tls transport would block" }
+func (tlsWouldBlock) Timeout() bool   { return false }
+func (tlsWouldBlock) Temporary() bool { return true }
+
+// tlsTransport is the net.Conn under a connection's tls.Conn, it is only used on the
+// loop goroutine. crypto/tls keeps the first error of a handshake for good, so a
+// handshake can't be resumed after a would-block error. It runs as a coroutine instead,
+// stepped by the loop: a read with no data buffered suspends it until the loop has fed
+// more and resumes it. After the handshake reads report a temporary error when no data
+// is buffered, which tls.Conn resumes from, and writes collect the records produced.
+type tlsTransport struct {
+	c   *conn
+	in  bytes.Buffer
+	out []byte
+	// yield suspends the handshake until the loop resumes it, it is nil once the handshake is over.
+	yield  func(struct{}) bool
+	closed bool
+}
+
+func (t *tlsTransport) Read(b []byte) (int, error) {
+	for t.yield != nil && !t.closed && t.in.Len() == 0 {
+		if !t.yield(struct{}{}) {
+			// the handshake was stopped by the connection closing
+			t.yield = nil
+			t.closed = true
+		}
+	}
+	if t.in.Len() > 0 {
+		return t.in.Read(b)
+	}
+	if t.closed {
+		return 0, io.EOF
+	}
+	return 0, tlsWouldBlock{}
+}
+
+func (t *tlsTransport) Write(b []byte) (int, error) {
+	if t.yield == nil {
+		t.out = append(t.out, b...)
+		return len(b), nil
+	}
+	// handshake flights go out as they are produced
+	if !t.c.closed.IsSet() {
+		t.c.enqueueSealed(append([]byte(nil), b...))
+	}
+	return len(b), nil
+}
+
+func (t *tlsTransport) feed(b []byte) {
+	t.in.Write(b)
+}
+
+// take returns the records written since the last call.
+func (t *tlsTransport) take() []byte {
+	out := t.out
+	t.out = nil
+	return out
+}
+
+func (t *tlsTransport) Close() error                     { return nil }
+func (t *tlsTransport) LocalAddr() net.Addr              { return t.c.localAddr }
+func (t *tlsTransport) RemoteAddr() net.Addr             { return t.c.remoteAddr }
+func (t *tlsTransport) SetDeadline(time.Time) error      { return nil }
+func (t *tlsTransport) SetReadDeadline(time.Time) error  { return nil }
+func (t *tlsTransport) SetWriteDeadline(time.Time) error { return nil }
+
+// handshakeLimit counts the handshakes in progress for a server or dialer, the
+// ones past its max wait in order for a slot to be released.
+type handshakeLimit struct {
+	mu      sync.Mutex
+	n       int
+	max     int
+	waiting []func()
+}
+
+func newHandshakeLimit(max int) *handshakeLimit {
+	if max <= 0 {
+		max = defaultMaxTLSHandshakes
+	}
+	return &handshakeLimit{max: max}
+}
+
+// acquire calls start once a slot is free, start must release it.
+func (l *handshakeLimit) acquire(start func()) {
+	l.mu.Lock()
+	if l.n >= l.max {
+		l.waiting = append(l.waiting, start)
+		l.mu.Unlock()
+		return
+	}
+	l.n++
+	l.mu.Unlock()
+	start()
+}
+
+// release hands the slot to the next waiting handshake.
+func (l *handshakeLimit) release() {
+	l.mu.Lock()
+	if len(l.waiting) == 0 {
+		l.n--
+		l.mu.Unlock()
+		return
+	}
+	start := l.waiting[0]
+	l.waiting[0] = nil
+	l.waiting = l.waiting[1:]
+	l.mu.Unlock()
+	start()
+}
+
+func (l *handshakeLimit) inProgress() (running, waiting int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.n, len(l.waiting)
+}
+
+type tlsState struct {
+	*tls.Conn
+	tr         *tlsTransport
+	timer      *Timer
+	handshaked util.AtomicBool
+	// pending holds sends made before the handshake completed.
+	pending []func()
+
+	// next resumes the handshake and stop abandons it, both are nil unless it is running.
+	next  func() (struct{}, bool)
+	stop  func()
+	err   error
+	limit *handshakeLimit
+}
+
+// startTLS runs the handshake on the loop once limit has a slot for it and opens the
+// connection once it succeeds, data read while it waits is buffered by the transport.
+func (c *conn) startTLS(config *tls.Config, client bool, limit *handshakeLimit, timeout time.Duration) {
+	tr := &tlsTransport{c: c}
+	st := &tlsState{tr: tr, limit: limit}
+	if client {
+		st.Conn = tls.Client(tr, config)
+	} else {
+		st.Conn = tls.Server(tr, config)
+	}
+	c.tlsState = st
+
+	// the slot may be handed over by a handshake finishing on another loop
+	limit.acquire(func() {
+		c.evLoop.Trigger(func() {
+			if c.closed.IsSet() {
+				limit.release()
+				return
+			}
+			if timeout > 0 {
+				st.timer = c.evLoop.AfterFunc(timeout, func() {
+					if !c.closed.IsSet() && !st.handshaked.IsSet() {
+						c.handleClose(c.fd, ErrTLSHandshakeTimeout)
+					}
+				})
+			}
+			st.next, st.stop = iter.Pull(func(yield func(struct{}) bool) {
+				tr.yield = yield
+				st.err = st.Handshake()
+			})
+			c.stepHandshake()
+		})
+	})
+}
+
+// stepHandshake resumes the handshake with the data fed so far, it runs on the loop
+// until it needs more from the peer or is done.
+func (c *conn) stepHandshake() {
+	st := c.tlsState
+	if st.next == nil {
+		// still waiting for a slot
+		return
+	}
+	if _, ok := st.next(); ok {
+		return
+	}
+	st.next, st.stop = nil, nil
+	st.tr.yield = nil
+	st.limit.release()
+	c.handshakeDone(st.err)
+}
+
+// close stops a handshake in progress and gives its slot back.
+func (st *tlsState) close() {
+	st.tr.closed = true
+	if st.stop == nil {
+		return
+	}
+	stop := st.stop
+	st.next, st.stop = nil, nil
+	stop()
+	st.tr.yield = nil
+	st.limit.release()
+}
+
+func (c *conn) handshakeDone(err error) {
+	if c.closed.IsSet() {
+		return
+	}
+	st := c.tlsState
+	if st.timer != nil {
+		st.timer.Stop()
+	}
+	if err != nil {
+		c.handleClose(c.fd, err)
+		return
+	}
+	st.handshaked.Set()
+
+	c.open()
+	for _, fn := range st.pending {
+		fn()
+	}
+	st.pending = nil
+	c.readTLS()
+}
+
+// deferUntilHandshake queues fn to run once the handshake completes, it
+// reports false when fn may run now.
+func (c *conn) deferUntilHandshake(fn func()) bool {
+	if c.tlsState == nil || c.tlsState.handshaked.IsSet() {
+		return false
+	}
+	c.tlsState.pending = append(c.tlsState.pending, fn)
+	return true
+}
+
+func (c *conn) readTLS() {
+	buf := c.evLoop.PacketBuf()
+	for !c.closed.IsSet() {
+		n, err := c.tlsState.Read(buf)
+		if n > 0 {
+			c.readBuf.Write(buf[:n])
+		}
+		if err != nil {
+			// a KeyUpdate may have been answered while reading
+			c.enqueueSealed(c.tlsState.tr.take())
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				break
+			}
+			c.handleClose(c.fd, err)
+			return
+		}
+	}
+	c.decodeFrames(c.readBuf)
+}
+
+// seal encrypts data into a single segment of records.
+func (c *conn) seal(data [][]byte) ([]byte, error) {
+	for _, b := range data {
+		if _, err := c.tlsState.Write(b); err != nil {
+			return nil, err
+		}
+	}
+	return c.tlsState.tr.take(), nil
+}
+
+// sealHead encrypts the next chunk at the head of the write queue when it is
+// plain data or a file range, records are sealed in the order they are written.
+func (c *conn) sealHead() error {
+	var data [][]byte
+	var consumed, plain int
+	if fr := c.writeQueue.headFile(); fr != nil {
+		buf := c.evLoop.PacketBuf()
+		if int64(len(buf)) > fr.remain {
+			buf = buf[:fr.remain]
+		}
+		n, err := syscall.Pread(fr.fd, buf, fr.offset)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		data, consumed = [][]byte{buf[:n]}, n
+	} else if c.writeQueue.headPlain() {
+		data = c.writeQueue.plainBuffers(maxSealSize)
+		for _, b := range data {
+			plain += len(b)
+		}
+		consumed = plain
+	} else {
+		return nil
+	}
+
+	sealed, err := c.seal(data)
+	if err != nil {
+		return err
+	}
+	c.writeQueue.advance(consumed)
+	c.writeQueue.pushSealed(sealed)
+	atomic.AddInt64(&c.queued, int64(len(sealed)-plain))
+	return nil
+}
+
+// closeNotify sends a close_notify alert straight to the socket, best effort.
+func (c *conn) closeNotify(fd int) {
+	if c.tlsState == nil || !c.tlsState.handshaked.IsSet() {
+		return
+	}
+	_ = c.tlsState.CloseWrite()
+	if out := c.tlsState.tr.take(); len(out) > 0 {
+		_, _ = syscall.Write(fd, out)
+	}
+}
@@ -0,0 +1,191 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed certificate for 127.0.0.1.
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLSHandshakeLimitAndTimeout(t *testing.T) {
+	srv := NewServer(NewOptions().
+		SetAddr("tcp://127.0.0.1:0").
+		SetNumLoops(1).
+		SetHandler(nopHandler{}).
+		SetTLSConfig(&tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}).
+		SetMaxTLSHandshakes(1).
+		SetTLSHandshakeTimeout(200 * time.Millisecond))
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+	handshakes := srv.(*server).handshakes
+	addr := listenAddr(t, srv).String()
+
+	// a peer that never sends a ClientHello holds the only handshake slot
+	stalled, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+	waitFor(t, "handshake", func() bool {
+		running, _ := handshakes.inProgress()
+		return running == 1
+	})
+
+	// the next client waits for the slot instead of being dropped
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		nc, err := net.Dial("tcp", addr)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer nc.Close()
+		tc := tls.Client(nc, &tls.Config{InsecureSkipVerify: true})
+		_ = tc.SetDeadline(time.Now().Add(2 * time.Second))
+		done <- tc.Handshake()
+	}()
+	waitFor(t, "queued handshake", func() bool {
+		_, waiting := handshakes.inProgress()
+		return waiting == 1
+	})
+	if n := srv.Count(); n != 0 {
+		t.Fatalf("Count() = %d during handshakes, want 0", n)
+	}
+
+	_ = stalled.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := stalled.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("stalled handshake: read %v, want EOF", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("queued handshake: %v", err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond || d > time.Second {
+		t.Fatalf("queued handshake done after %s, want once the stalled one timed out", d)
+	}
+	waitFor(t, "release", func() bool {
+		running, waiting := handshakes.inProgress()
+		return running == 0 && waiting == 0
+	})
+}
+
+type openedHandler struct {
+	nopHandler
+	opened int32
+}
+
+func (h *openedHandler) OnOpen(c Connection) {
+	atomic.StoreInt32(&h.opened, 1)
+}
+
+func TestDialWaitsForTLSHandshake(t *testing.T) {
+	cert := testCertificate(t)
+	srv := NewServer(NewOptions().
+		SetAddr("tcp://127.0.0.1:0").
+		SetNumLoops(1).
+		SetHandler(nopHandler{}).
+		SetTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}))
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+	addr := "tcp://" + listenAddr(t, srv).String()
+
+	h := &openedHandler{}
+	d := NewDialer(NewOptions().SetNumLoops(1).SetHandler(h).SetTLSConfig(&tls.Config{InsecureSkipVerify: true}))
+	defer d.Close()
+	c, err := d.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.TLSConnectionState(); !ok || atomic.LoadInt32(&h.opened) != 1 {
+		t.Fatalf("Dial returned before the handshake and OnOpen: handshake done %v", ok)
+	}
+
+	// a failed handshake is reported by Dial instead of a connection that never opens
+	strict := NewDialer(NewOptions().SetNumLoops(1).SetHandler(nopHandler{}).SetTLSConfig(&tls.Config{}))
+	defer strict.Close()
+	if c, err := strict.Dial(addr); err == nil {
+		t.Fatalf("Dial with an untrusted certificate = %v, want an error", c)
+	}
+}
+
+// trickleConn writes in small pieces, so the server gets each flight over many reads.
+type trickleConn struct {
+	net.Conn
+}
+
+func (tc trickleConn) Write(b []byte) (int, error) {
+	for i := 0; i < len(b); i += 7 {
+		end := i + 7
+		if end > len(b) {
+			end = len(b)
+		}
+		if _, err := tc.Conn.Write(b[i:end]); err != nil {
+			return i, err
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return len(b), nil
+}
+
+func TestTLSHandshakeSteppedByReads(t *testing.T) {
+	srv := NewServer(NewOptions().
+		SetAddr("tcp://127.0.0.1:0").
+		SetNumLoops(1).
+		SetHandler(echoHandler{}).
+		SetTLSConfig(&tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}))
+	go srv.Start()
+	defer srv.Shutdown(context.Background())
+	handshakes := srv.(*server).handshakes
+
+	nc, err := net.Dial("tcp", listenAddr(t, srv).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	tc := tls.Client(trickleConn{nc}, &tls.Config{InsecureSkipVerify: true})
+	_ = tc.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := tc.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	// application data right behind the handshake is decrypted once it completes
+	if _, err := tc.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(tc, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo = %q, %v", buf, err)
+	}
+	if running, waiting := handshakes.inProgress(); running != 0 || waiting != 0 {
+		t.Fatalf("%d handshakes running and %d waiting after completion", running, waiting)
+	}
+}
@@ -6,3 +6,7 @@ type AtomicBool int32
 
 func (b *AtomicBool) IsSet() bool { return atomic.LoadInt32((*int32)(b)) != 0 }
 func (b *AtomicBool) Set()        { atomic.StoreInt32((*int32)(b), 1) }
+func (b *AtomicBool) Unset()      { atomic.StoreInt32((*int32)(b), 0) }
+
+// TrySet sets b and reports whether it was previously unset.
+func (b *AtomicBool) TrySet() bool { return atomic.CompareAndSwapInt32((*int32)(b), 0, 1) }
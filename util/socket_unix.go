@@ -0,0 +1,72 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package util
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+func SockAddrFamily(sa syscall.Sockaddr) int {
+	switch sa.(type) {
+	case *syscall.SockaddrInet6:
+		return syscall.AF_INET6
+	case *syscall.SockaddrUnix:
+		return syscall.AF_UNIX
+	}
+	return syscall.AF_INET
+}
+
+func NewNonblockSocket(sa syscall.Sockaddr, sotype int) (int, error) {
+	syscall.ForkLock.RLock()
+	fd, err := syscall.Socket(SockAddrFamily(sa), sotype, 0)
+	if err == nil {
+		syscall.CloseOnExec(fd)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return -1, err
+	}
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		_ = syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// MaxIovecs is the most buffers passed to a single Writev call.
+const MaxIovecs = 1024
+
+func Writev(fd int, buffers [][]byte) (int, error) {
+	iovecs := make([]syscall.Iovec, 0, len(buffers))
+	for _, b := range buffers {
+		if len(b) == 0 {
+			continue
+		}
+		iov := syscall.Iovec{Base: &b[0]}
+		iov.SetLen(len(b))
+		iovecs = append(iovecs, iov)
+		if len(iovecs) == MaxIovecs {
+			break
+		}
+	}
+	if len(iovecs) == 0 {
+		return 0, nil
+	}
+	n, _, errno := syscall.Syscall(syscall.SYS_WRITEV, uintptr(fd), uintptr(unsafe.Pointer(&iovecs[0])), uintptr(len(iovecs)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func DupCloseOnExec(fd int) (int, error) {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	nfd, err := syscall.Dup(fd)
+	if err != nil {
+		return -1, err
+	}
+	syscall.CloseOnExec(nfd)
+	return nfd, nil
+}
@@ -51,3 +51,33 @@ func TemporaryErr(err error) bool {
 	}
 	return errno.Temporary()
 }
+
+func ResolveSockAddr(network, address string) (syscall.Sockaddr, net.Addr, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		addr, err := net.ResolveTCPAddr(network, address)
+		if err != nil {
+			return nil, nil, err
+		}
+		return IPToSockAddr(addr.IP, addr.Port, addr.Zone), addr, nil
+	case "unix", "unixpacket":
+		return &syscall.SockaddrUnix{Name: address}, &net.UnixAddr{Net: network, Name: address}, nil
+	}
+	return nil, nil, net.UnknownNetworkError(network)
+}
+
+func IPToSockAddr(ip net.IP, port int, zone string) syscall.Sockaddr {
+	if ip4 := ip.To4(); ip4 != nil {
+		sa := &syscall.SockaddrInet4{Port: port}
+		copy(sa.Addr[:], ip4)
+		return sa
+	}
+	sa := &syscall.SockaddrInet6{Port: port}
+	copy(sa.Addr[:], ip.To16())
+	if zone != "" {
+		if ifi, err := net.InterfaceByName(zone); err == nil {
+			sa.ZoneId = uint32(ifi.Index)
+		}
+	}
+	return sa
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"net"
 	"strconv"
 
 	"github.com/dreamans/evnio"
@@ -194,7 +195,8 @@ func (c *Conn) readFramePayload() (OpCode, []byte, int) {
 		// TODO: check multiFrameOpCode == OpNone error
 
 		c.multiFrameOpCode = OpNone
-		c.readBuf = c.readBuf[0:0]
+		// the handler keeps the message, the next one gets its own buffer
+		c.readBuf = nil
 
 		return opCode, b, len(c.segmentBuf)
 	}
@@ -258,17 +260,22 @@ func (c *Conn) writeFrame(final bool, opCode OpCode, data []byte) error {
 		headerBuf[1] = b1 | byte(length)
 	}
 
-	frame := make([]byte, len(data)+headerPos)
-	copy(frame[:headerPos], headerBuf[:headerPos])
-	copy(frame[headerPos:], data)
-	return c.conn.Send(frame, evnio.ActionNone)
+	return c.conn.SendBuffers(net.Buffers{headerBuf[:headerPos], data}, evnio.ActionNone)
 }
 
 func (c *Conn) Close() error {
 	return c.conn.Close()
 }
 
+// WriteMessage copies data into the frames it queues, data may be reused once it returns.
 func (c *Conn) WriteMessage(opCode OpCode, data []byte) error {
+	return c.WriteMessageNoCopy(opCode, append([]byte(nil), data...))
+}
+
+// WriteMessageNoCopy queues data without a copy. The connection owns data from the call
+// until it is written, like Connection.SendBuffers the caller must not modify or reuse it,
+// so only pass a buffer nothing else writes to, such as a message given to Handler.OnMessage.
+func (c *Conn) WriteMessageNoCopy(opCode OpCode, data []byte) error {
 	if len(data) <= c.maxPayloadSize {
 		return c.writeFrame(true, opCode, data)
 	}
@@ -297,7 +304,7 @@ func (c *Conn) writeControl(opCode OpCode, b []byte) error {
 	if len(b) > maxControlFramePayloadSize {
 		return errInvalidControlFrame
 	}
-	return c.writeFrame(true, OpClose, b)
+	return c.writeFrame(true, OpClose, append([]byte(nil), b...))
 }
 
 func (c *Conn) WriteCloseMessage(closeCode int, text string) error {
@@ -2,6 +2,7 @@ package websocket
 
 import (
 	"bytes"
+	"net"
 
 	"github.com/dreamans/evnio"
 )
@@ -26,3 +27,7 @@ func (p *Protocol) UnPacket(c evnio.Connection, buffer *bytes.Buffer) []byte {
 func (p *Protocol) Packet(c evnio.Connection, data []byte) []byte {
 	return data
 }
+
+func (p *Protocol) PacketBuffers(c evnio.Connection, buffers net.Buffers) net.Buffers {
+	return buffers
+}
@@ -0,0 +1,167 @@
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package evnio
+
+import (
+	"syscall"
+
+	"github.com/dreamans/evnio/util"
+)
+
+// fileRange is a region of a file queued by SendFile, fd is a private dup closed once sent.
+type fileRange struct {
+	fd     int
+	offset int64
+	remain int64
+}
+
+type segment struct {
+	buf  []byte
+	file *fileRange
+	// plain marks data of a TLS connection that is encrypted once it reaches the head,
+	// so records hit the wire in the order they were sealed.
+	plain bool
+}
+
+func (s *segment) Len() int {
+	if s.file != nil {
+		return int(s.file.remain)
+	}
+	return len(s.buf)
+}
+
+// writeQueue holds outbound segments in order without copying them, it is only
+// accessed from the connection's loop goroutine.
+type writeQueue struct {
+	segs []segment
+	iov  [][]byte
+	size int
+}
+
+func (q *writeQueue) Len() int {
+	return q.size
+}
+
+func (q *writeQueue) push(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	q.segs = append(q.segs, segment{buf: b})
+	q.size += len(b)
+}
+
+func (q *writeQueue) pushPlain(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	q.segs = append(q.segs, segment{buf: b, plain: true})
+	q.size += len(b)
+}
+
+// pushSealed inserts b after the bytes at the head of the queue that are ready to be written.
+func (q *writeQueue) pushSealed(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	i := 0
+	for i < len(q.segs) && q.segs[i].file == nil && !q.segs[i].plain {
+		i++
+	}
+	q.segs = append(q.segs, segment{})
+	copy(q.segs[i+1:], q.segs[i:])
+	q.segs[i] = segment{buf: b}
+	q.size += len(b)
+}
+
+func (q *writeQueue) pushFile(fr *fileRange) {
+	if fr.remain <= 0 {
+		_ = syscall.Close(fr.fd)
+		return
+	}
+	q.segs = append(q.segs, segment{file: fr})
+	q.size += int(fr.remain)
+}
+
+// headFile returns the file range at the head of the queue, or nil if it starts with bytes.
+func (q *writeQueue) headFile() *fileRange {
+	if len(q.segs) == 0 {
+		return nil
+	}
+	return q.segs[0].file
+}
+
+// headPlain reports whether the head of the queue is data waiting to be encrypted.
+func (q *writeQueue) headPlain() bool {
+	return len(q.segs) > 0 && q.segs[0].plain
+}
+
+// buffers returns the byte segments at the head of the queue up to the next
+// file range or plain segment, at most util.MaxIovecs of them.
+func (q *writeQueue) buffers() [][]byte {
+	q.iov = q.iov[:0]
+	for i := 0; i < len(q.segs) && i < util.MaxIovecs && q.segs[i].file == nil && !q.segs[i].plain; i++ {
+		q.iov = append(q.iov, q.segs[i].buf)
+	}
+	return q.iov
+}
+
+// plainBuffers returns up to limit bytes of the plain segments at the head of the queue.
+func (q *writeQueue) plainBuffers(limit int) [][]byte {
+	q.iov = q.iov[:0]
+	for i := 0; i < len(q.segs) && limit > 0 && q.segs[i].plain; i++ {
+		b := q.segs[i].buf
+		if len(b) > limit {
+			b = b[:limit]
+		}
+		q.iov = append(q.iov, b)
+		limit -= len(b)
+	}
+	return q.iov
+}
+
+// advance drops n written bytes from the head of the queue.
+func (q *writeQueue) advance(n int) {
+	for i := range q.iov {
+		q.iov[i] = nil
+	}
+	q.size -= n
+	i := 0
+	for ; i < len(q.segs) && n >= q.segs[i].Len(); i++ {
+		n -= q.segs[i].Len()
+		q.release(i)
+	}
+	if i < len(q.segs) && n > 0 {
+		if fr := q.segs[i].file; fr != nil {
+			fr.offset += int64(n)
+			fr.remain -= int64(n)
+		} else {
+			q.segs[i].buf = q.segs[i].buf[n:]
+		}
+	}
+	if i == 0 {
+		return
+	}
+	m := copy(q.segs, q.segs[i:])
+	for j := m; j < len(q.segs); j++ {
+		q.segs[j] = segment{}
+	}
+	q.segs = q.segs[:m]
+}
+
+func (q *writeQueue) reset() {
+	for i := range q.segs {
+		q.release(i)
+	}
+	for i := range q.iov {
+		q.iov[i] = nil
+	}
+	q.segs = q.segs[:0]
+	q.size = 0
+}
+
+func (q *writeQueue) release(i int) {
+	if fr := q.segs[i].file; fr != nil {
+		_ = syscall.Close(fr.fd)
+	}
+	q.segs[i] = segment{}
+}